@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// analytics report cells farther than this from their bathroom unless the
+// request sets its own threshold
+const defaultFarThreshold = 30
+
+// width of the distance histogram buckets unless the request sets its own
+const defaultHistogramBucket = 5
+
+// CoverageStats sums up how well the bathrooms of a map serve its walkable
+// cells. The mean and max only count cells which reach a bathroom.
+type CoverageStats struct {
+	WalkableCells    int     `json:"walkableCells"`
+	UnreachableCells int     `json:"unreachableCells"`
+	MeanDistance     float64 `json:"meanDistance"`
+	MaxDistance      int     `json:"maxDistance"`
+}
+
+// coverageStats sums up the distances flood returned
+func coverageStats(distances [][][]int, floors [][][]int) CoverageStats {
+	var stats CoverageStats
+	total, reachable := 0, 0
+	for floor, matrix := range floors {
+		for x := range matrix {
+			for y, cell := range matrix[x] {
+				if cell == CellWall {
+					continue
+				}
+				stats.WalkableCells += 1
+				distance := distances[floor][x][y]
+				if distance == Unreachable {
+					stats.UnreachableCells += 1
+					continue
+				}
+				reachable += 1
+				total += distance
+				if distance > stats.MaxDistance {
+					stats.MaxDistance = distance
+				}
+			}
+		}
+	}
+	if reachable > 0 {
+		stats.MeanDistance = float64(total) / float64(reachable)
+	}
+	return stats
+}
+
+// BathroomCoverage describes the region of one bathroom. Area counts the cells
+// it is nearest to and FarCells lists the ones beyond the report threshold.
+type BathroomCoverage struct {
+	BathroomID   int       `json:"bathroomId"`
+	Bathroom     *Bathroom `json:"bathroom,omitempty"`
+	Area         int       `json:"area"`
+	MeanDistance float64   `json:"meanDistance"`
+	MaxDistance  int       `json:"maxDistance"`
+	FarCells     []Cell    `json:"farCells"`
+}
+
+// HistogramBucket counts the cells whose distance to the nearest bathroom is at
+// least From and below To
+type HistogramBucket struct {
+	From  int `json:"from"`
+	To    int `json:"to"`
+	Count int `json:"count"`
+}
+
+// AnalyticsReport is the coverage report of a stored map
+type AnalyticsReport struct {
+	ID        int                `json:"ID"`
+	Threshold int                `json:"threshold"`
+	Coverage  CoverageStats      `json:"coverage"`
+	Bathrooms []BathroomCoverage `json:"bathrooms"`
+	Histogram []HistogramBucket  `json:"histogram"`
+}
+
+// analyzeCoverage builds the per bathroom and histogram parts of the report
+// from the labels and distances flood returned
+func analyzeCoverage(labels, distances [][][]int, bathrooms []Bathroom, threshold, bucket int) ([]BathroomCoverage, []HistogramBucket) {
+	regions := make(map[int]*BathroomCoverage)
+	totals := make(map[int]int)
+	var histogram []HistogramBucket
+	for floor := range labels {
+		for x := range labels[floor] {
+			for y, id := range labels[floor][x] {
+				if id <= 0 {
+					continue
+				}
+				distance := distances[floor][x][y]
+				region, ok := regions[id]
+				if !ok {
+					region = &BathroomCoverage{BathroomID: id, Bathroom: findBathroomByID(bathrooms, id), FarCells: make([]Cell, 0)}
+					regions[id] = region
+				}
+				region.Area += 1
+				totals[id] += distance
+				if distance > region.MaxDistance {
+					region.MaxDistance = distance
+				}
+				if distance > threshold {
+					region.FarCells = append(region.FarCells, Cell{floor, x, y})
+				}
+
+				for len(histogram) <= distance/bucket {
+					from := len(histogram) * bucket
+					histogram = append(histogram, HistogramBucket{From: from, To: from + bucket})
+				}
+				histogram[distance/bucket].Count += 1
+			}
+		}
+	}
+
+	coverage := make([]BathroomCoverage, 0, len(regions))
+	for id, region := range regions {
+		region.MeanDistance = float64(totals[id]) / float64(region.Area)
+		coverage = append(coverage, *region)
+	}
+	sort.Slice(coverage, func(i, j int) bool {
+		return coverage[i].BathroomID < coverage[j].BathroomID
+	})
+	if histogram == nil {
+		histogram = make([]HistogramBucket, 0)
+	}
+	return coverage, histogram
+}
+
+// coverage report of a stored map, GET /api/maps/{id}/analytics with optional
+// threshold and bucket query parameters
+func mapAnalyticsHandler(w http.ResponseWriter, r *http.Request, id int) {
+	//Allow only GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	threshold, err := queryInt(r, "threshold", defaultFarThreshold)
+	if err != nil || threshold < 0 {
+		http.Error(w, "threshold must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+	bucket, err := queryInt(r, "bucket", defaultHistogramBucket)
+	if err != nil || bucket < 1 {
+		http.Error(w, "bucket must be a positive integer", http.StatusBadRequest)
+		return
+	}
+
+	bathroomMapOutput, err := mapStore.Get(id)
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	bathroomMap := ConvertOutputToMap(bathroomMapOutput)
+
+	b, err := newBuilding(bathroomMap.availableFloors(time.Now()), bathroomMap.Connectors, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	labels, distances := b.flood(b.findSites(bathroomMap.Bathrooms, nil))
+
+	report := AnalyticsReport{ID: id, Threshold: threshold, Coverage: coverageStats(distances, b.floors)}
+	report.Bathrooms, report.Histogram = analyzeCoverage(labels, distances, bathroomMap.Bathrooms, threshold, bucket)
+
+	jsonResponse, err := json.Marshal(report)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
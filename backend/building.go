@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Connector kinds, stairs are left out of accessible-only searches
+const (
+	ConnectorStairs   = "stairs"
+	ConnectorElevator = "elevator"
+)
+
+// cost of riding a connector when the map does not set one, elevators include
+// some time spent waiting for the car
+var defaultConnectorCosts = map[string]int{
+	ConnectorStairs:   10,
+	ConnectorElevator: 15,
+}
+
+// Connector links two walkable cells on different floors, like the landings of
+// a staircase or the doors of an elevator. It can be walked both ways and a
+// Cost of zero uses the default for its Kind.
+type Connector struct {
+	Kind string `json:"kind"`
+	From Cell   `json:"from"`
+	To   Cell   `json:"to"`
+	Cost int    `json:"cost"`
+}
+
+// floorPoint is a cell on one floor of a building
+type floorPoint struct {
+	floor int
+	point Point
+}
+
+// buildingSite is a bathroom on one floor of a building
+type buildingSite struct {
+	at floorPoint
+	id int
+}
+
+// move from one cell to another and what it costs
+type floorLink struct {
+	to   floorPoint
+	cost int
+}
+
+// building is the walkable graph formed by one or more floor grids, joined by
+// connectors. A plain map is a building with a single floor.
+type building struct {
+	floors [][][]int
+	costs  CellCosts
+	links  map[floorPoint][]floorLink
+}
+
+// newBuilding checks every floor and connector and indexes the connectors by
+// the cells they start from
+func newBuilding(floors [][][]int, connectors []Connector, costs CellCosts) (building, error) {
+	if len(floors) == 0 {
+		return building{}, errors.New("map has no floors")
+	}
+	for floor, matrix := range floors {
+		if err := validateMatrix(matrix); err != nil {
+			return building{}, fmt.Errorf("floor %d: %w", floor, err)
+		}
+	}
+	if err := costs.validate(); err != nil {
+		return building{}, err
+	}
+
+	b := building{floors: floors, costs: costs, links: make(map[floorPoint][]floorLink)}
+	for i, connector := range connectors {
+		defaultCost, ok := defaultConnectorCosts[connector.Kind]
+		if !ok {
+			return building{}, fmt.Errorf("connector %d: unknown kind %q", i, connector.Kind)
+		}
+		cost := connector.Cost
+		if cost == 0 {
+			cost = defaultCost
+		}
+		if cost < 1 {
+			return building{}, fmt.Errorf("connector %d: cost must be at least 1", i)
+		}
+
+		from, to := b.cellPoint(connector.From), b.cellPoint(connector.To)
+		if !b.walkable(from) || !b.walkable(to) {
+			return building{}, fmt.Errorf("connector %d: both ends must be walkable cells", i)
+		}
+		b.links[from] = append(b.links[from], floorLink{to, cost})
+		b.links[to] = append(b.links[to], floorLink{from, cost})
+	}
+	return b, nil
+}
+
+// convert an API cell into a point in the building
+func (b building) cellPoint(cell Cell) floorPoint {
+	return floorPoint{cell.Floor, Point{cell.Row, cell.Col}}
+}
+
+// check the point is inside the building and not a wall
+func (b building) walkable(fp floorPoint) bool {
+	if fp.floor < 0 || fp.floor >= len(b.floors) {
+		return false
+	}
+	matrix := b.floors[fp.floor]
+	if checkWithinBounds(fp.point, len(matrix), len(matrix[0])) {
+		return false
+	}
+	return matrix[fp.point.x][fp.point.y] != CellWall
+}
+
+// every cell reachable in one move from fp and the cost of getting there
+func (b building) moves(fp floorPoint) []floorLink {
+	matrix := b.floors[fp.floor]
+	neighbors := getNeighbors(fp.point, matrix)
+	moves := make([]floorLink, 0, len(neighbors)+len(b.links[fp]))
+	for _, neighbor := range neighbors {
+		cost := b.costs.cost(matrix[neighbor.x][neighbor.y])
+		moves = append(moves, floorLink{floorPoint{fp.floor, neighbor}, cost})
+	}
+	return append(moves, b.links[fp]...)
+}
+
+// findSites collects the bathrooms on every floor, keeping only the ones that
+// match filter when it is set
+func (b building) findSites(bathrooms []Bathroom, filter *BathroomFilter) []buildingSite {
+	sites := make([]buildingSite, 0)
+	for floor, matrix := range b.floors {
+		voronoiPoints, _ := FindBathrooms(matrix)
+		if filter != nil {
+			voronoiPoints = filterSites(voronoiPoints, bathrooms, *filter)
+		}
+		sites = append(sites, sitesOnFloor(floor, voronoiPoints)...)
+	}
+	return sites
+}
+
+// place voronoi points on a floor of the building
+func sitesOnFloor(floor int, voronoiPoints []VoronoiPoint) []buildingSite {
+	sites := make([]buildingSite, len(voronoiPoints))
+	for i, voronoiPoint := range voronoiPoints {
+		sites[i] = buildingSite{floorPoint{floor, voronoiPoint.point}, voronoiPoint.id}
+	}
+	return sites
+}
+
+// drop the connectors a wheelchair cannot use
+func accessibleConnectors(connectors []Connector) []Connector {
+	accessible := make([]Connector, 0, len(connectors))
+	for _, connector := range connectors {
+		if connector.Kind != ConnectorStairs {
+			accessible = append(accessible, connector)
+		}
+	}
+	return accessible
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFloodAcrossConnectors(t *testing.T) {
+	floors := [][][]int{
+		{{1, 0}},
+		{{0, 0}},
+	}
+	elevator := Connector{Kind: ConnectorElevator, From: Cell{0, 0, 1}, To: Cell{1, 0, 0}}
+	stairs := Connector{Kind: ConnectorStairs, From: Cell{0, 0, 1}, To: Cell{1, 0, 1}, Cost: 3}
+
+	tests := []struct {
+		name          string
+		connectors    []Connector
+		wantLabels    [][][]int
+		wantDistances [][][]int
+	}{
+		{"floors without connectors", nil,
+			[][][]int{{{1, 1}}, {{0, 0}}},
+			[][][]int{{{0, 1}}, {{Unreachable, Unreachable}}}},
+		{"elevator at its default cost", []Connector{elevator},
+			[][][]int{{{1, 1}}, {{1, 1}}},
+			[][][]int{{{0, 1}}, {{16, 17}}}},
+		{"cheaper stairs win", []Connector{elevator, stairs},
+			[][][]int{{{1, 1}}, {{1, 1}}},
+			[][][]int{{{0, 1}}, {{5, 4}}}},
+		{"accessible routes skip the stairs", accessibleConnectors([]Connector{elevator, stairs}),
+			[][][]int{{{1, 1}}, {{1, 1}}},
+			[][][]int{{{0, 1}}, {{16, 17}}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := newBuilding(floors, test.connectors, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			labels, distances := b.flood(b.findSites(nil, nil))
+			if !reflect.DeepEqual(labels, test.wantLabels) {
+				t.Errorf("labels = %v, want %v", labels, test.wantLabels)
+			}
+			if !reflect.DeepEqual(distances, test.wantDistances) {
+				t.Errorf("distances = %v, want %v", distances, test.wantDistances)
+			}
+		})
+	}
+}
+
+func TestNewBuildingErrors(t *testing.T) {
+	floors := [][][]int{{{1, -1}}, {{0, 0}}}
+	tests := []struct {
+		name      string
+		floors    [][][]int
+		connector Connector
+	}{
+		{"no floors", nil, Connector{Kind: ConnectorStairs, From: Cell{0, 0, 0}, To: Cell{0, 0, 0}}},
+		{"unknown kind", floors, Connector{Kind: "escalator", From: Cell{0, 0, 0}, To: Cell{1, 0, 0}}},
+		{"negative cost", floors, Connector{Kind: ConnectorStairs, From: Cell{0, 0, 0}, To: Cell{1, 0, 0}, Cost: -1}},
+		{"end on a wall", floors, Connector{Kind: ConnectorStairs, From: Cell{0, 0, 1}, To: Cell{1, 0, 0}}},
+		{"end off the map", floors, Connector{Kind: ConnectorStairs, From: Cell{0, 0, 0}, To: Cell{2, 0, 0}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := newBuilding(test.floors, []Connector{test.connector}, nil); err == nil {
+				t.Error("newBuilding() succeeded, want an error")
+			}
+		})
+	}
+}
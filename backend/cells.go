@@ -0,0 +1,74 @@
+package main
+
+import "errors"
+
+// Reserved grid codes. Positive values are bathroom IDs and -1 is a wall, the
+// remaining negative codes are passable cells that take longer to cross than
+// open floor.
+const (
+	CellWall    = -1
+	CellFree    = 0
+	CellDoor    = -2
+	CellStairs  = -3
+	CellRamp    = -4
+	CellCrowded = -5
+	CellOutdoor = -6
+	CellSteps   = -7
+)
+
+// CellCosts maps a grid code to the cost of stepping onto a cell with that
+// code. Codes missing from the map fall back to defaultCellCosts and then to 1.
+type CellCosts map[int]int
+
+// cost of stepping onto an open floor or bathroom cell is one, everything else
+// is relative to that
+var defaultCellCosts = CellCosts{
+	CellDoor:    2,
+	CellStairs:  4,
+	CellRamp:    2,
+	CellCrowded: 3,
+	CellOutdoor: 2,
+	CellSteps:   2,
+}
+
+// wheelchairBarriers can be walked over on foot but not in a wheelchair
+var wheelchairBarriers = map[int]bool{
+	CellStairs: true,
+	CellSteps:  true,
+}
+
+// cost of stepping onto a cell holding code
+func (costs CellCosts) cost(code int) int {
+	if cost, ok := costs[code]; ok {
+		return cost
+	}
+	if cost, ok := defaultCellCosts[code]; ok {
+		return cost
+	}
+	return 1
+}
+
+// costs must be positive for the shortest path searches to be correct
+func (costs CellCosts) validate() error {
+	for _, cost := range costs {
+		if cost < 1 {
+			return errors.New("cell costs must be at least 1")
+		}
+	}
+	return nil
+}
+
+// copy of matrix with every wheelchair barrier turned into a wall
+func accessibleMatrix(matrix [][]int) [][]int {
+	accessible := make([][]int, len(matrix))
+	for x, row := range matrix {
+		accessible[x] = make([]int, len(row))
+		for y, code := range row {
+			if wheelchairBarriers[code] {
+				code = CellWall
+			}
+			accessible[x][y] = code
+		}
+	}
+	return accessible
+}
@@ -0,0 +1,58 @@
+package main
+
+// UnisexGender is the Bathroom.Gender used for bathrooms open to everyone.
+const UnisexGender = "U"
+
+// BathroomFilter selects the bathrooms that count as Voronoi sites. Zero
+// fields do not restrict anything.
+type BathroomFilter struct {
+	// Gender keeps bathrooms of this gender along with unisex ones
+	Gender            string `json:"gender"`
+	Accessible        bool   `json:"accessible"`
+	MenstrualProducts bool   `json:"menstrualProducts"`
+}
+
+// Matches reports whether bathroom meets every criterion of the filter.
+func (filter BathroomFilter) Matches(bathroom Bathroom) bool {
+	if filter.Gender != "" && bathroom.Gender != filter.Gender && bathroom.Gender != UnisexGender {
+		return false
+	}
+	if filter.Accessible && !bathroom.Accessible {
+		return false
+	}
+	if filter.MenstrualProducts && !bathroom.MenstrualProduct {
+		return false
+	}
+	return true
+}
+
+// filterSites joins grid sites to their Bathroom records by ID and keeps the
+// ones matching filter. Sites without a record are dropped since nothing is
+// known about them.
+func filterSites(sites []VoronoiPoint, bathrooms []Bathroom, filter BathroomFilter) []VoronoiPoint {
+	filtered := make([]VoronoiPoint, 0, len(sites))
+	for _, site := range sites {
+		bathroom := findBathroomByID(bathrooms, site.id)
+		if bathroom != nil && filter.Matches(*bathroom) {
+			filtered = append(filtered, site)
+		}
+	}
+	return filtered
+}
+
+// applyAccessibleOnly walls off every wheelchair barrier on every floor, drops
+// the stair connectors and narrows filter down to accessible bathrooms, so
+// nobody is sent down a staircase to reach a bathroom they can use.
+func applyAccessibleOnly(floors [][][]int, connectors []Connector, filter *BathroomFilter) ([][][]int, []Connector, *BathroomFilter) {
+	accessibleFilter := BathroomFilter{}
+	if filter != nil {
+		accessibleFilter = *filter
+	}
+	accessibleFilter.Accessible = true
+
+	accessibleFloors := make([][][]int, len(floors))
+	for floor, matrix := range floors {
+		accessibleFloors[floor] = accessibleMatrix(matrix)
+	}
+	return accessibleFloors, accessibleConnectors(connectors), &accessibleFilter
+}
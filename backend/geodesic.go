@@ -0,0 +1,103 @@
+package main
+
+import "container/heap"
+
+// Unreachable is the distance reported for walls and for cells that cannot
+// reach any bathroom.
+const Unreachable = -1
+
+// VoronoiExact computes the geodesic Voronoi diagram of matrix by flooding
+// outward from every bathroom at once over the same 4-connected grid that
+// getNeighbors walks, charging each step the cost of the cell it enters. Walls
+// are labeled -1, cells that no bathroom can reach are labeled 0 and every
+// other cell gets the ID of its nearest bathroom. Ties are broken in favor of
+// the lower bathroom ID, so the output is the same on every run.
+func VoronoiExact(matrix [][]int, voronoiPoints []VoronoiPoint, costs CellCosts) [][]int {
+	labels, _ := geodesicVoronoi(matrix, voronoiPoints, costs)
+	return labels
+}
+
+// VoronoiDistances is VoronoiExact plus a parallel matrix holding the walking
+// cost from every cell to its nearest bathroom, or Unreachable.
+func VoronoiDistances(matrix [][]int, voronoiPoints []VoronoiPoint, costs CellCosts) ([][]int, [][]int) {
+	return geodesicVoronoi(matrix, voronoiPoints, costs)
+}
+
+// entry of the flood queue, ordered by distance and then by bathroom id
+type floodItem struct {
+	at       floorPoint
+	distance int
+	id       int
+}
+
+type floodQueue []floodItem
+
+func (fq floodQueue) Len() int { return len(fq) }
+func (fq floodQueue) Less(i, j int) bool {
+	return fq[i].distance < fq[j].distance || (fq[i].distance == fq[j].distance && fq[i].id < fq[j].id)
+}
+func (fq floodQueue) Swap(i, j int)       { fq[i], fq[j] = fq[j], fq[i] }
+func (fq *floodQueue) Push(x interface{}) { *fq = append(*fq, x.(floodItem)) }
+func (fq *floodQueue) Pop() interface{} {
+	old := *fq
+	n := len(old)
+	item := old[n-1]
+	*fq = old[0 : n-1]
+	return item
+}
+
+// single floor version of building.flood
+func geodesicVoronoi(matrix [][]int, voronoiPoints []VoronoiPoint, costs CellCosts) ([][]int, [][]int) {
+	b := building{floors: [][][]int{matrix}, costs: costs}
+	labels, distances := b.flood(sitesOnFloor(0, voronoiPoints))
+	return labels[0], distances[0]
+}
+
+// flood runs a multi-source Dijkstra from every site and returns, per floor,
+// the owning bathroom and the walking cost of every cell
+func (b building) flood(sites []buildingSite) ([][][]int, [][][]int) {
+	labels := make([][][]int, len(b.floors))
+	distances := make([][][]int, len(b.floors))
+	for floor, matrix := range b.floors {
+		labels[floor] = make([][]int, len(matrix))
+		distances[floor] = make([][]int, len(matrix))
+		for x := range matrix {
+			labels[floor][x] = make([]int, len(matrix[x]))
+			distances[floor][x] = make([]int, len(matrix[x]))
+			for y := range matrix[x] {
+				distances[floor][x][y] = Unreachable
+				if matrix[x][y] == CellWall {
+					labels[floor][x][y] = CellWall
+				}
+			}
+		}
+	}
+
+	// seed the queue with every bathroom
+	queue := make(floodQueue, 0, len(sites))
+	for _, site := range sites {
+		heap.Push(&queue, floodItem{site.at, 0, site.id})
+	}
+
+	// items come out ordered by (distance, id) so the first item to settle a
+	// cell is its nearest bathroom, with ties going to the lower id
+	for len(queue) > 0 {
+		item := heap.Pop(&queue).(floodItem)
+		floor, current := item.at.floor, item.at.point
+		if distances[floor][current.x][current.y] != Unreachable {
+			continue
+		}
+		distances[floor][current.x][current.y] = item.distance
+		labels[floor][current.x][current.y] = item.id
+
+		for _, move := range b.moves(item.at) {
+			next := move.to
+			if distances[next.floor][next.point.x][next.point.y] != Unreachable {
+				continue
+			}
+			heap.Push(&queue, floodItem{next, item.distance + move.cost, item.id})
+		}
+	}
+
+	return labels, distances
+}
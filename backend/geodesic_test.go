@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVoronoiDistances(t *testing.T) {
+	tests := []struct {
+		name          string
+		matrix        [][]int
+		costs         CellCosts
+		wantLabels    [][]int
+		wantDistances [][]int
+	}{
+		{"single bathroom", [][]int{{1, 0, 0}}, nil,
+			[][]int{{1, 1, 1}},
+			[][]int{{0, 1, 2}}},
+		{"equidistant tie goes to the lower ID", [][]int{{2, 0, 1}}, nil,
+			[][]int{{2, 1, 1}},
+			[][]int{{0, 1, 0}}},
+		{"walls separate regions", [][]int{
+			{1, 0, 0, 0, 0},
+			{-1, -1, -1, -1, 0},
+			{0, 0, 0, 0, 2},
+		}, nil,
+			[][]int{
+				{1, 1, 1, 1, 2},
+				{-1, -1, -1, -1, 2},
+				{2, 2, 2, 2, 2},
+			},
+			[][]int{
+				{0, 1, 2, 3, 2},
+				{-1, -1, -1, -1, 1},
+				{4, 3, 2, 1, 0},
+			}},
+		{"unreachable pocket", [][]int{{1, 0, -1, 0}}, nil,
+			[][]int{{1, 1, -1, 0}},
+			[][]int{{0, 1, Unreachable, Unreachable}}},
+		{"no bathrooms", [][]int{{0, 0}}, nil,
+			[][]int{{0, 0}},
+			[][]int{{Unreachable, Unreachable}}},
+		{"stairs cost more to cross", [][]int{{1, CellStairs, 0, 2}}, nil,
+			[][]int{{1, 1, 2, 2}},
+			[][]int{{0, 4, 1, 0}}},
+		{"costs override the defaults", [][]int{{1, CellStairs, 0, 0, 2}}, CellCosts{CellStairs: 1},
+			[][]int{{1, 1, 1, 2, 2}},
+			[][]int{{0, 1, 2, 1, 0}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			voronoiPoints, _ := FindBathrooms(test.matrix)
+			labels, distances := VoronoiDistances(test.matrix, voronoiPoints, test.costs)
+			if !reflect.DeepEqual(labels, test.wantLabels) {
+				t.Errorf("labels = %v, want %v", labels, test.wantLabels)
+			}
+			if !reflect.DeepEqual(distances, test.wantDistances) {
+				t.Errorf("distances = %v, want %v", distances, test.wantDistances)
+			}
+		})
+	}
+}
+
+func TestVoronoiExactIsDeterministic(t *testing.T) {
+	// a bathroom drawn over several cells and many ties between 3 and 5
+	matrix := [][]int{
+		{5, 0, 0, 0, 3},
+		{5, 0, -1, 0, 3},
+		{0, 0, 0, 0, 0},
+	}
+	voronoiPoints, _ := FindBathrooms(matrix)
+	want := VoronoiExact(matrix, voronoiPoints, nil)
+	// the order the bathrooms are given in does not matter
+	reversed := make([]VoronoiPoint, len(voronoiPoints))
+	for i, voronoiPoint := range voronoiPoints {
+		reversed[len(voronoiPoints)-1-i] = voronoiPoint
+	}
+	if got := VoronoiExact(matrix, reversed, nil); !reflect.DeepEqual(got, want) {
+		t.Errorf("labels = %v, want %v", got, want)
+	}
+	if want[0][2] != 3 || want[2][2] != 3 {
+		t.Errorf("ties went to %d and %d, want 3", want[0][2], want[2][2])
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// GeoJSONFeatureCollection is a GeoJSON (RFC 7946) feature collection
+type GeoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJSONFeature `json:"features"`
+}
+
+// GeoJSONFeature is one region of a Voronoi diagram
+type GeoJSONFeature struct {
+	Type       string           `json:"type"`
+	Geometry   GeoJSONGeometry  `json:"geometry"`
+	Properties RegionProperties `json:"properties"`
+}
+
+// GeoJSONGeometry is a Polygon, whose coordinates are rings of [lng, lat]
+// positions, or a MultiPolygon, a list of those
+type GeoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+// RegionProperties carries the bathroom of a region along with the floor it
+// is on and how many cells it covers
+type RegionProperties struct {
+	Bathroom
+	Floor int `json:"floor"`
+	Cells int `json:"cells"`
+}
+
+// corner of a grid cell, cell (x, y) spans corners (x, y) to (x+1, y+1)
+type gridCorner struct {
+	row int
+	col int
+}
+
+// gridEdge runs along a cell side with the region on its right
+type gridEdge struct {
+	from gridCorner
+	to   gridCorner
+}
+
+// regionEdges lists the sides of the cells labeled id which face another label
+// or the outside of the grid, going round every cell the same way
+func regionEdges(labels [][]int, id int) []gridEdge {
+	differs := func(x, y int) bool {
+		return x < 0 || x >= len(labels) || y < 0 || y >= len(labels[x]) || labels[x][y] != id
+	}
+	var edges []gridEdge
+	for x := range labels {
+		for y, label := range labels[x] {
+			if label != id {
+				continue
+			}
+			topLeft, topRight := gridCorner{x, y}, gridCorner{x, y + 1}
+			bottomRight, bottomLeft := gridCorner{x + 1, y + 1}, gridCorner{x + 1, y}
+			if differs(x-1, y) {
+				edges = append(edges, gridEdge{topLeft, topRight})
+			}
+			if differs(x, y+1) {
+				edges = append(edges, gridEdge{topRight, bottomRight})
+			}
+			if differs(x+1, y) {
+				edges = append(edges, gridEdge{bottomRight, bottomLeft})
+			}
+			if differs(x, y-1) {
+				edges = append(edges, gridEdge{bottomLeft, topLeft})
+			}
+		}
+	}
+	return edges
+}
+
+// traceRings chains edges into closed rings. Where two cells of the region
+// only touch at a corner the ring turns towards the region, so the cells end
+// up in separate outer rings. The cells outside the region are still chained
+// through such corners, so rings are split wherever they come back to a corner
+// and no ring touches itself.
+func traceRings(edges []gridEdge) [][]gridCorner {
+	outgoing := make(map[gridCorner][]int)
+	for i, edge := range edges {
+		outgoing[edge.from] = append(outgoing[edge.from], i)
+	}
+	used := make([]bool, len(edges))
+
+	var rings [][]gridCorner
+	for first := range edges {
+		if used[first] {
+			continue
+		}
+		start := edges[first].from
+		var ring []gridCorner
+		for current := first; ; {
+			used[current] = true
+			edge := edges[current]
+			ring = append(ring, edge.from)
+			if edge.to == start {
+				break
+			}
+			current = nextEdge(edges, outgoing[edge.to], used, edge)
+		}
+		for _, loop := range splitRing(ring) {
+			rings = append(rings, simplifyRing(loop))
+		}
+	}
+	return rings
+}
+
+// splitRing cuts a ring into simple loops at the corners it visits twice, so
+// two holes touching at a corner become two holes
+func splitRing(ring []gridCorner) [][]gridCorner {
+	var loops [][]gridCorner
+	seen := make(map[gridCorner]int)
+	var stack []gridCorner
+	for _, corner := range ring {
+		if i, ok := seen[corner]; ok {
+			loop := make([]gridCorner, len(stack)-i)
+			copy(loop, stack[i:])
+			loops = append(loops, loop)
+			for _, dropped := range stack[i:] {
+				delete(seen, dropped)
+			}
+			stack = stack[:i]
+		}
+		seen[corner] = len(stack)
+		stack = append(stack, corner)
+	}
+	return append(loops, stack)
+}
+
+// drop the corners where a ring goes straight on
+func simplifyRing(ring []gridCorner) []gridCorner {
+	simplified := make([]gridCorner, 0, len(ring))
+	for i, corner := range ring {
+		previous, next := ring[(i+len(ring)-1)%len(ring)], ring[(i+1)%len(ring)]
+		if !collinear(previous, corner, next) {
+			simplified = append(simplified, corner)
+		}
+	}
+	return simplified
+}
+
+// direction of an edge as a row and col step
+func direction(edge gridEdge) gridCorner {
+	return gridCorner{edge.to.row - edge.from.row, edge.to.col - edge.from.col}
+}
+
+// nextEdge picks the unused edge to follow after edge, preferring a right turn,
+// then going straight and then a left turn
+func nextEdge(edges []gridEdge, candidates []int, used []bool, edge gridEdge) int {
+	heading := direction(edge)
+	right := gridCorner{heading.col, -heading.row}
+	left := gridCorner{-heading.col, heading.row}
+	best, bestRank := -1, 0
+	for _, candidate := range candidates {
+		if used[candidate] {
+			continue
+		}
+		rank := 3
+		switch direction(edges[candidate]) {
+		case right:
+			rank = 0
+		case heading:
+			rank = 1
+		case left:
+			rank = 2
+		}
+		if best == -1 || rank < bestRank {
+			best, bestRank = candidate, rank
+		}
+	}
+	return best
+}
+
+// check b lies on the straight line from a to c
+func collinear(a, b, c gridCorner) bool {
+	return (b.row-a.row)*(c.col-b.col) == (b.col-a.col)*(c.row-b.row)
+}
+
+// twice the signed area of a ring, negative for the outer rings regionEdges
+// produces and positive for holes
+func ringArea(ring []gridCorner) int {
+	area := 0
+	for i, corner := range ring {
+		next := ring[(i+1)%len(ring)]
+		area += corner.row*next.col - next.row*corner.col
+	}
+	return area
+}
+
+// even-odd test of a point against a ring
+func ringContains(ring []gridCorner, row, col float64) bool {
+	inside := false
+	for i, corner := range ring {
+		next := ring[(i+1)%len(ring)]
+		a, b := float64(corner.row), float64(next.row)
+		if (a > row) != (b > row) {
+			crossing := float64(corner.col) + (row-a)*float64(next.col-corner.col)/(b-a)
+			if col < crossing {
+				inside = !inside
+			}
+		}
+	}
+	return inside
+}
+
+// regionPolygons vectorizes the cells labeled id into polygons, each an outer
+// ring followed by its holes
+func regionPolygons(labels [][]int, id int) [][][]gridCorner {
+	var polygons [][][]gridCorner
+	var holes [][]gridCorner
+	for _, ring := range traceRings(regionEdges(labels, id)) {
+		if ringArea(ring) < 0 {
+			polygons = append(polygons, [][]gridCorner{ring})
+		} else {
+			holes = append(holes, ring)
+		}
+	}
+
+	// a hole belongs to the smallest outer ring around the cell to the right
+	// of its first side
+	for _, hole := range holes {
+		heading := gridCorner{sign(hole[1].row - hole[0].row), sign(hole[1].col - hole[0].col)}
+		row := float64(hole[0].row) + float64(heading.row+heading.col)/2
+		col := float64(hole[0].col) + float64(heading.col-heading.row)/2
+		best := -1
+		for i, polygon := range polygons {
+			if !ringContains(polygon[0], row, col) {
+				continue
+			}
+			if best == -1 || ringArea(polygon[0]) > ringArea(polygons[best][0]) {
+				best = i
+			}
+		}
+		if best != -1 {
+			polygons[best] = append(polygons[best], hole)
+		}
+	}
+	return polygons
+}
+
+func sign(n int) int {
+	if n < 0 {
+		return -1
+	} else if n > 0 {
+		return 1
+	}
+	return 0
+}
+
+// geoJSONRing places a ring on the earth as closed [lng, lat] positions, wound
+// counterclockwise for outer rings and clockwise for holes as RFC 7946 asks
+func geoJSONRing(transform geoTransform, ring []gridCorner, outer bool) [][2]float64 {
+	positions := make([][2]float64, 0, len(ring)+1)
+	for _, corner := range ring {
+		position := transform.position(float64(corner.row), float64(corner.col))
+		positions = append(positions, [2]float64{position.Lng, position.Lat})
+	}
+	positions = append(positions, positions[0])
+
+	area := 0.0
+	for i := 0; i+1 < len(positions); i++ {
+		area += positions[i][0]*positions[i+1][1] - positions[i+1][0]*positions[i][1]
+	}
+	if (area > 0) != outer {
+		for i, j := 0, len(positions)-1; i < j; i, j = i+1, j-1 {
+			positions[i], positions[j] = positions[j], positions[i]
+		}
+	}
+	return positions
+}
+
+// voronoiGeoJSON turns the labels of every floor into one feature per
+// bathroom and floor
+func voronoiGeoJSON(transform geoTransform, labels [][][]int, bathrooms []Bathroom) GeoJSONFeatureCollection {
+	collection := GeoJSONFeatureCollection{Type: "FeatureCollection", Features: make([]GeoJSONFeature, 0)}
+	for floor, matrix := range labels {
+		cells := make(map[int]int)
+		for x := range matrix {
+			for _, id := range matrix[x] {
+				if id > 0 {
+					cells[id] += 1
+				}
+			}
+		}
+		ids := make([]int, 0, len(cells))
+		for id := range cells {
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+
+		for _, id := range ids {
+			var polygons [][][][2]float64
+			for _, polygon := range regionPolygons(matrix, id) {
+				rings := make([][][2]float64, len(polygon))
+				for i, ring := range polygon {
+					rings[i] = geoJSONRing(transform, ring, i == 0)
+				}
+				polygons = append(polygons, rings)
+			}
+			geometry := GeoJSONGeometry{Type: "MultiPolygon", Coordinates: polygons}
+			if len(polygons) == 1 {
+				geometry = GeoJSONGeometry{Type: "Polygon", Coordinates: polygons[0]}
+			}
+
+			properties := RegionProperties{Bathroom: Bathroom{ID: id}, Floor: floor, Cells: cells[id]}
+			if bathroom := findBathroomByID(bathrooms, id); bathroom != nil {
+				properties.Bathroom = *bathroom
+			}
+			collection.Features = append(collection.Features, GeoJSONFeature{"Feature", geometry, properties})
+		}
+	}
+	return collection
+}
+
+// Voronoi regions of a stored map as GeoJSON, GET /api/maps/{id}/geojson
+func mapGeoJSONHandler(w http.ResponseWriter, r *http.Request, id int) {
+	//Allow only GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bathroomMapOutput, err := mapStore.Get(id)
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	bathroomMap := ConvertOutputToMap(bathroomMapOutput)
+
+	transform, err := bathroomMap.geoTransform()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	b, err := newBuilding(bathroomMap.availableFloors(time.Now()), bathroomMap.Connectors, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	labels, _ := b.flood(b.findSites(bathroomMap.Bathrooms, nil))
+
+	jsonResponse, err := json.Marshal(voronoiGeoJSON(transform, labels, bathroomMap.Bathrooms))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/geo+json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
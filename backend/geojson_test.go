@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRegionPolygons(t *testing.T) {
+	tests := []struct {
+		name   string
+		labels [][]int
+		// twice the signed area of every ring, polygon by polygon
+		want [][]int
+	}{
+		{"single cell", [][]int{{1}}, [][]int{{-2}}},
+		{"donut", [][]int{
+			{1, 1, 1},
+			{1, 0, 1},
+			{1, 1, 1},
+		}, [][]int{{-18, 2}}},
+		{"cells touching diagonally", [][]int{
+			{1, 0},
+			{0, 1},
+		}, [][]int{{-2}, {-2}}},
+		{"holes touching diagonally", [][]int{
+			{1, 1, 1, 1},
+			{1, 0, 1, 1},
+			{1, 1, 0, 1},
+			{1, 1, 1, 1},
+		}, [][]int{{-32, 2, 2}}},
+		{"hole of several labels", [][]int{
+			{1, 1, 1, 1},
+			{1, 0, 1, 1},
+			{1, 2, 0, 1},
+			{1, 1, 1, 1},
+		}, [][]int{{-32, 6}}},
+		{"hole touching the outer ring", [][]int{
+			{1, 1, 0},
+			{1, 0, 1},
+			{1, 1, 1},
+		}, [][]int{{-16, 2}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			polygons := regionPolygons(test.labels, 1)
+			got := make([][]int, len(polygons))
+			for i, polygon := range polygons {
+				for _, ring := range polygon {
+					got[i] = append(got[i], ringArea(ring))
+					seen := make(map[gridCorner]bool)
+					for _, corner := range ring {
+						if seen[corner] {
+							t.Errorf("ring %v visits corner %v twice", ring, corner)
+						}
+						seen[corner] = true
+					}
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ring areas = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// mean radius of the earth in meters
+const earthRadius = 6371008.8
+
+var errNotGeoreferenced = errors.New("map has no coordinates")
+
+// geoTransform places the cells of a grid on the earth. origin is the outer
+// corner of row 0, col 0 and rowStep and colStep are how far one row and one
+// column move in latitude and longitude, so a rotated grid simply has both
+// parts of each step set. Over the size of a building lat/lng is flat enough
+// for the transform to be affine.
+type geoTransform struct {
+	origin  Coordinates
+	rowStep Coordinates
+	colStep Coordinates
+	rows    int
+	cols    int
+}
+
+// newGeoTransform builds the transform of a rows by cols grid from the corners
+// stored with a map. The editor stores the north east and south west corners
+// of an upright grid. A rotated grid adds the corner of row 0, col 0 as a
+// third coordinate, the first two then being the far end of row 0 and the far
+// end of col 0.
+func newGeoTransform(coordinates []Coordinates, rows, cols int) (geoTransform, error) {
+	if len(coordinates) < 2 {
+		return geoTransform{}, errNotGeoreferenced
+	}
+	if len(coordinates) > 3 {
+		return geoTransform{}, errors.New("coordinates must list two or three corners")
+	}
+	if rows == 0 || cols == 0 {
+		return geoTransform{}, errors.New("cannot georeference an empty grid")
+	}
+
+	rowEnd, colEnd := coordinates[0], coordinates[1]
+	origin := Coordinates{Lat: rowEnd.Lat, Lng: colEnd.Lng}
+	if len(coordinates) == 3 {
+		origin = coordinates[2]
+	}
+	transform := geoTransform{
+		origin:  origin,
+		rowStep: Coordinates{(colEnd.Lat - origin.Lat) / float64(rows), (colEnd.Lng - origin.Lng) / float64(rows)},
+		colStep: Coordinates{(rowEnd.Lat - origin.Lat) / float64(cols), (rowEnd.Lng - origin.Lng) / float64(cols)},
+		rows:    rows,
+		cols:    cols,
+	}
+	if transform.determinant() == 0 {
+		return geoTransform{}, errors.New("coordinates do not span an area")
+	}
+	return transform, nil
+}
+
+// geoTransform of the map, sized by its first floor
+func (bathroomMap BathroomMap) geoTransform() (geoTransform, error) {
+	matrix := bathroomMap.floorGrids()[0]
+	cols := 0
+	if len(matrix) > 0 {
+		cols = len(matrix[0])
+	}
+	return newGeoTransform(bathroomMap.Coordinates, len(matrix), cols)
+}
+
+func (transform geoTransform) determinant() float64 {
+	return transform.rowStep.Lat*transform.colStep.Lng - transform.colStep.Lat*transform.rowStep.Lng
+}
+
+// position of a fractional grid position, whole numbers being cell corners
+func (transform geoTransform) position(row, col float64) Coordinates {
+	return Coordinates{
+		Lat: transform.origin.Lat + row*transform.rowStep.Lat + col*transform.colStep.Lat,
+		Lng: transform.origin.Lng + row*transform.rowStep.Lng + col*transform.colStep.Lng,
+	}
+}
+
+// position of the center of a cell
+func (transform geoTransform) cellCenter(point Point) Coordinates {
+	return transform.position(float64(point.x)+0.5, float64(point.y)+0.5)
+}
+
+// gridPosition is the inverse of position
+func (transform geoTransform) gridPosition(position Coordinates) (float64, float64) {
+	lat, lng := position.Lat-transform.origin.Lat, position.Lng-transform.origin.Lng
+	det := transform.determinant()
+	row := (lat*transform.colStep.Lng - transform.colStep.Lat*lng) / det
+	col := (transform.rowStep.Lat*lng - lat*transform.rowStep.Lng) / det
+	return row, col
+}
+
+// cellAt finds the cell holding position, false when it is outside the grid
+func (transform geoTransform) cellAt(position Coordinates) (Point, bool) {
+	row, col := transform.gridPosition(position)
+	x, y := int(math.Floor(row)), int(math.Floor(col))
+	if x < 0 || y < 0 || x >= transform.rows || y >= transform.cols {
+		return Point{}, false
+	}
+	return Point{x, y}, true
+}
+
+// corners of the grid, clockwise from the outer corner of row 0, col 0
+func (transform geoTransform) corners() []Coordinates {
+	rows, cols := float64(transform.rows), float64(transform.cols)
+	return []Coordinates{
+		transform.position(0, 0),
+		transform.position(0, cols),
+		transform.position(rows, cols),
+		transform.position(rows, 0),
+	}
+}
+
+// cellSize is the height and width of one cell in meters
+func (transform geoTransform) cellSize() (float64, float64) {
+	return metersBetween(transform.origin, transform.position(1, 0)), metersBetween(transform.origin, transform.position(0, 1))
+}
+
+// metersBetween is the great circle distance between two positions
+func metersBetween(a, b Coordinates) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat, dLng := lat2-lat1, (b.Lng-a.Lng)*math.Pi/180
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
+
+// positions of the centers of the given cells
+func (transform geoTransform) cellPositions(points []floorPoint) []Coordinates {
+	positions := make([]Coordinates, len(points))
+	for i, fp := range points {
+		positions[i] = transform.cellCenter(fp.point)
+	}
+	return positions
+}
+
+// GeoReference describes where a stored map lies. Corners run clockwise from
+// the outer corner of row 0, col 0 and the cell sizes are in meters.
+type GeoReference struct {
+	Corners    []Coordinates `json:"corners"`
+	Rows       int           `json:"rows"`
+	Cols       int           `json:"cols"`
+	CellHeight float64       `json:"cellHeight"`
+	CellWidth  float64       `json:"cellWidth"`
+}
+
+// GeoCell pairs a grid cell with the position of its center
+type GeoCell struct {
+	Cell
+	Position Coordinates `json:"position"`
+}
+
+// read a float query parameter, ok is false when it is not set
+func queryFloat(r *http.Request, name string) (float64, bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return 0, false, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	return f, true, err
+}
+
+// where a stored map lies, GET /api/maps/{id}/geo. With lat and lng it returns
+// the cell holding that position, with row and col the position of that cell.
+func mapGeoHandler(w http.ResponseWriter, r *http.Request, id int) {
+	//Allow only GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bathroomMapOutput, err := mapStore.Get(id)
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	transform, err := ConvertOutputToMap(bathroomMapOutput).geoTransform()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	lat, hasLat, latErr := queryFloat(r, "lat")
+	lng, hasLng, lngErr := queryFloat(r, "lng")
+	floor, floorErr := queryInt(r, "floor", 0)
+	row, rowErr := queryInt(r, "row", -1)
+	col, colErr := queryInt(r, "col", -1)
+	if latErr != nil || lngErr != nil || floorErr != nil || rowErr != nil || colErr != nil {
+		http.Error(w, "Invalid query parameters", http.StatusBadRequest)
+		return
+	}
+
+	var response interface{}
+	switch {
+	case hasLat || hasLng:
+		if !hasLat || !hasLng {
+			http.Error(w, "lat and lng must be given together", http.StatusBadRequest)
+			return
+		}
+		point, ok := transform.cellAt(Coordinates{lat, lng})
+		if !ok {
+			http.Error(w, "Position is outside the map", http.StatusBadRequest)
+			return
+		}
+		response = GeoCell{Cell{floor, point.x, point.y}, transform.cellCenter(point)}
+	case row != -1 || col != -1:
+		if row < 0 || col < 0 || row >= transform.rows || col >= transform.cols {
+			http.Error(w, "Cell is outside the map", http.StatusBadRequest)
+			return
+		}
+		response = GeoCell{Cell{floor, row, col}, transform.cellCenter(Point{row, col})}
+	default:
+		cellHeight, cellWidth := transform.cellSize()
+		response = GeoReference{transform.corners(), transform.rows, transform.cols, cellHeight, cellWidth}
+	}
+
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// an upright 4 by 8 grid from its north east and south west corners
+var uprightCorners = []Coordinates{{Lat: 40.001, Lng: -73.998}, {Lat: 40.000, Lng: -74.000}}
+
+// a 2 by 2 grid turned 45 degrees, the third corner being row 0, col 0
+var rotatedCorners = []Coordinates{{Lat: 0.002, Lng: 0.002}, {Lat: -0.002, Lng: 0.002}, {Lat: 0, Lng: 0}}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestGeoTransformPosition(t *testing.T) {
+	upright, err := newGeoTransform(uprightCorners, 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := newGeoTransform(rotatedCorners, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		transform geoTransform
+		row, col  float64
+		want      Coordinates
+	}{
+		{"upright origin is the north west corner", upright, 0, 0, Coordinates{Lat: 40.001, Lng: -74.000}},
+		{"upright far corner is the south east corner", upright, 4, 8, Coordinates{Lat: 40.000, Lng: -73.998}},
+		{"upright cell center", upright, 0.5, 0.5, Coordinates{Lat: 40.000875, Lng: -73.999875}},
+		{"rotated origin", rotated, 0, 0, Coordinates{Lat: 0, Lng: 0}},
+		{"rotated end of row 0", rotated, 0, 2, Coordinates{Lat: 0.002, Lng: 0.002}},
+		{"rotated end of col 0", rotated, 2, 0, Coordinates{Lat: -0.002, Lng: 0.002}},
+		{"rotated far corner", rotated, 2, 2, Coordinates{Lat: 0, Lng: 0.004}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.transform.position(test.row, test.col)
+			if !closeTo(got.Lat, test.want.Lat) || !closeTo(got.Lng, test.want.Lng) {
+				t.Errorf("position(%v, %v) = %v, want %v", test.row, test.col, got, test.want)
+			}
+			row, col := test.transform.gridPosition(got)
+			if !closeTo(row, test.row) || !closeTo(col, test.col) {
+				t.Errorf("gridPosition(%v) = %v, %v, want %v, %v", got, row, col, test.row, test.col)
+			}
+		})
+	}
+}
+
+func TestGeoTransformCellAt(t *testing.T) {
+	upright, err := newGeoTransform(uprightCorners, 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := newGeoTransform(rotatedCorners, 2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		transform geoTransform
+		position  Coordinates
+		want      Point
+		wantOK    bool
+	}{
+		{"upright first cell", upright, Coordinates{Lat: 40.0009, Lng: -73.9999}, Point{0, 0}, true},
+		{"upright last cell", upright, Coordinates{Lat: 40.0001, Lng: -73.9981}, Point{3, 7}, true},
+		{"north of the grid", upright, Coordinates{Lat: 40.0011, Lng: -73.999}, Point{}, false},
+		{"east of the grid", upright, Coordinates{Lat: 40.0005, Lng: -73.9979}, Point{}, false},
+		{"rotated row 0, col 1", rotated, Coordinates{Lat: 0.0015, Lng: 0.002}, Point{0, 1}, true},
+		{"rotated row 1, col 0", rotated, Coordinates{Lat: -0.0015, Lng: 0.002}, Point{1, 0}, true},
+		{"inside the bounding box of a rotated grid", rotated, Coordinates{Lat: 0.0015, Lng: 0.0005}, Point{}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := test.transform.cellAt(test.position)
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("cellAt(%v) = %v, %v, want %v, %v", test.position, got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+
+	// every cell holds its own center
+	for _, transform := range []geoTransform{upright, rotated} {
+		for x := 0; x < transform.rows; x++ {
+			for y := 0; y < transform.cols; y++ {
+				if got, ok := transform.cellAt(transform.cellCenter(Point{x, y})); !ok || got != (Point{x, y}) {
+					t.Errorf("center of cell %v is in cell %v, %v", Point{x, y}, got, ok)
+				}
+			}
+		}
+	}
+}
+
+func TestNewGeoTransformErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		coordinates []Coordinates
+		rows, cols  int
+		wantErr     error
+	}{
+		{"no coordinates", nil, 4, 8, errNotGeoreferenced},
+		{"one corner", uprightCorners[:1], 4, 8, errNotGeoreferenced},
+		{"four corners", append(rotatedCorners, Coordinates{}), 4, 8, nil},
+		{"empty grid", uprightCorners, 0, 8, nil},
+		{"corners on one line", []Coordinates{{Lat: 40, Lng: -73.998}, {Lat: 40, Lng: -74}}, 4, 8, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newGeoTransform(test.coordinates, test.rows, test.cols)
+			if err == nil {
+				t.Fatal("newGeoTransform() succeeded, want an error")
+			}
+			if test.wantErr != nil && !errors.Is(err, test.wantErr) {
+				t.Errorf("newGeoTransform() = %v, want %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCellSize(t *testing.T) {
+	upright, err := newGeoTransform(uprightCorners, 4, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	height, width := upright.cellSize()
+	// a quarter of a thousandth of a degree, narrower east to west at 40 degrees north
+	if math.Abs(height-27.80) > 0.01 {
+		t.Errorf("cell height = %v meters, want 27.80", height)
+	}
+	if want := height * math.Cos(40.001*math.Pi/180); math.Abs(width-want) > 0.01 {
+		t.Errorf("cell width = %v meters, want %v", width, want)
+	}
+}
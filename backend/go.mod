@@ -1,3 +1,10 @@
 module github.com/daminals/bathroom-geometry
 
 go 1.21.5
+
+require (
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/crypto v0.17.0
+)
+
+require golang.org/x/sys v0.15.0 // indirect
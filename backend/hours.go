@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	// opening hours need time zones even on hosts without a zoneinfo database
+	_ "time/tzdata"
+)
+
+// layouts of the times and dates in opening hours
+const (
+	hoursLayout = "15:04"
+	dateLayout  = "2006-01-02"
+)
+
+// TimeRange is an opening from Open until Close, both "HH:MM". A Close at or
+// before Open runs past midnight into the next day, and "24:00" is midnight at
+// the end of the day.
+type TimeRange struct {
+	Open  string `json:"open"`
+	Close string `json:"close"`
+}
+
+// HoursException replaces the weekly hours on one date, "YYYY-MM-DD" in the
+// time zone of the schedule. An exception without hours is closed all day.
+type HoursException struct {
+	Date  string      `json:"date"`
+	Hours []TimeRange `json:"hours"`
+}
+
+// OpeningHours is the weekly schedule of a bathroom. Weekly is keyed by the
+// lowercase English day name and days left out are closed. TimeZone is an IANA
+// name like "America/New_York", UTC when empty.
+type OpeningHours struct {
+	TimeZone   string                 `json:"timeZone"`
+	Weekly     map[string][]TimeRange `json:"weekly"`
+	Exceptions []HoursException       `json:"exceptions,omitempty"`
+}
+
+// minutes since midnight of an "HH:MM" time, "24:00" included
+func parseMinutes(value string) (int, error) {
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse(hoursLayout, value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// the opening and closing minute of a range
+func (timeRange TimeRange) minutes() (int, int, error) {
+	open, err := parseMinutes(timeRange.Open)
+	if err != nil {
+		return 0, 0, err
+	}
+	closing, err := parseMinutes(timeRange.Close)
+	if err != nil {
+		return 0, 0, err
+	}
+	if open == 24*60 {
+		return 0, 0, errors.New("a range cannot open at 24:00")
+	}
+	return open, closing, nil
+}
+
+func (hours OpeningHours) validate() error {
+	if _, err := time.LoadLocation(hours.TimeZone); err != nil {
+		return fmt.Errorf("unknown time zone %q", hours.TimeZone)
+	}
+	for day, ranges := range hours.Weekly {
+		if _, ok := weekdays[day]; !ok {
+			return fmt.Errorf("unknown day %q", day)
+		}
+		for _, timeRange := range ranges {
+			if _, _, err := timeRange.minutes(); err != nil {
+				return fmt.Errorf("%s: %w", day, err)
+			}
+		}
+	}
+	for _, exception := range hours.Exceptions {
+		if _, err := time.Parse(dateLayout, exception.Date); err != nil {
+			return fmt.Errorf("invalid exception date %q, want YYYY-MM-DD", exception.Date)
+		}
+		for _, timeRange := range exception.Hours {
+			if _, _, err := timeRange.minutes(); err != nil {
+				return fmt.Errorf("%s: %w", exception.Date, err)
+			}
+		}
+	}
+	return nil
+}
+
+// day names accepted in OpeningHours.Weekly
+var weekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+// the ranges which start on the date of day, an exception wins over the
+// weekly hours
+func (hours OpeningHours) rangesOn(day time.Time) []TimeRange {
+	date := day.Format(dateLayout)
+	for _, exception := range hours.Exceptions {
+		if exception.Date == date {
+			return exception.Hours
+		}
+	}
+	return hours.Weekly[strings.ToLower(day.Weekday().String())]
+}
+
+// openAt checks whether the schedule is open at t, counting ranges which
+// started the day before and run past midnight. Schedules which do not
+// validate are never open.
+func (hours OpeningHours) openAt(t time.Time) bool {
+	location, err := time.LoadLocation(hours.TimeZone)
+	if err != nil {
+		return false
+	}
+	local := t.In(location)
+	minute := local.Hour()*60 + local.Minute()
+
+	for _, timeRange := range hours.rangesOn(local) {
+		open, closing, err := timeRange.minutes()
+		if err != nil {
+			continue
+		}
+		if minute >= open && (closing <= open || minute < closing) {
+			return true
+		}
+	}
+	for _, timeRange := range hours.rangesOn(local.AddDate(0, 0, -1)) {
+		open, closing, err := timeRange.minutes()
+		if err != nil {
+			continue
+		}
+		if closing <= open && minute < closing {
+			return true
+		}
+	}
+	return false
+}
+
+// validateBathrooms checks the opening hours of every bathroom
+func validateBathrooms(bathrooms []Bathroom) error {
+	for _, bathroom := range bathrooms {
+		if bathroom.Hours == nil {
+			continue
+		}
+		if err := bathroom.Hours.validate(); err != nil {
+			return fmt.Errorf("bathroom %d: %w", bathroom.ID, err)
+		}
+	}
+	return nil
+}
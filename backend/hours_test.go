@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOpenAt(t *testing.T) {
+	hours := OpeningHours{
+		TimeZone: "America/New_York",
+		Weekly: map[string][]TimeRange{
+			"monday":   {{"09:00", "17:00"}},
+			"tuesday":  {{"08:00", "12:00"}},
+			"thursday": {{"22:00", "02:00"}},
+			"saturday": {{"00:00", "24:00"}},
+		},
+		Exceptions: []HoursException{
+			{Date: "2024-06-04"},
+			{Date: "2024-06-11", Hours: []TimeRange{{"10:00", "11:00"}}},
+			{Date: "2024-06-13"},
+		},
+	}
+	newYork, err := time.LoadLocation(hours.TimeZone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, newYork)
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want bool
+	}{
+		{"before opening", local(3, 8, 59), false},
+		{"at opening", local(3, 9, 0), true},
+		{"before closing", local(3, 16, 59), true},
+		{"at closing", local(3, 17, 0), false},
+		{"day left out", local(5, 12, 0), false},
+		{"overnight before midnight", local(6, 23, 0), true},
+		{"overnight after midnight", local(7, 1, 59), true},
+		{"overnight at closing", local(7, 2, 0), false},
+		{"open until 24:00", local(8, 23, 59), true},
+		{"24:00 does not run into the next day", local(9, 0, 0), false},
+		{"closed by an exception", local(4, 10, 0), false},
+		{"hours of an exception", local(11, 10, 30), true},
+		{"exception replaces the weekly hours", local(11, 9, 0), false},
+		{"exception the day before closes the overnight range", local(14, 1, 0), false},
+		{"in the time zone of the schedule", time.Date(2024, time.June, 3, 13, 0, 0, 0, time.UTC), true},
+		{"outside the time zone of the schedule", time.Date(2024, time.June, 3, 12, 59, 0, 0, time.UTC), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := hours.openAt(test.at); got != test.want {
+				t.Errorf("openAt(%v) = %v, want %v", test.at, got, test.want)
+			}
+		})
+	}
+}
+
+func TestOpeningHoursValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		hours   OpeningHours
+		wantErr bool
+	}{
+		{"valid", OpeningHours{TimeZone: "Europe/London", Weekly: map[string][]TimeRange{"friday": {{"22:00", "02:00"}}}}, false},
+		{"empty time zone is UTC", OpeningHours{Weekly: map[string][]TimeRange{"monday": {{"00:00", "24:00"}}}}, false},
+		{"unknown time zone", OpeningHours{TimeZone: "Mars/Olympus"}, true},
+		{"unknown day", OpeningHours{Weekly: map[string][]TimeRange{"Monday": {{"09:00", "17:00"}}}}, true},
+		{"invalid time", OpeningHours{Weekly: map[string][]TimeRange{"monday": {{"9am", "17:00"}}}}, true},
+		{"opening at 24:00", OpeningHours{Weekly: map[string][]TimeRange{"monday": {{"24:00", "02:00"}}}}, true},
+		{"invalid exception date", OpeningHours{Exceptions: []HoursException{{Date: "06/04/2024"}}}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.hours.validate()
+			if (err != nil) != test.wantErr {
+				t.Errorf("validate() = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ImpactRequest asks what happens when the bathrooms in Close are taken out of
+// a map, either the stored map with ID or the one given inline
+type ImpactRequest struct {
+	ID         int         `json:"ID"`
+	Matrix     [][]int     `json:"matrix"`
+	Floors     [][][]int   `json:"floors"`
+	Connectors []Connector `json:"connectors"`
+	Bathrooms  []Bathroom  `json:"bathrooms"`
+	Close      []int       `json:"close"`
+	Costs      CellCosts   `json:"costs"`
+}
+
+// OwnerChange is a cell whose nearest bathroom changes and how much farther it
+// has to walk
+type OwnerChange struct {
+	Cell
+	From     int `json:"from"`
+	To       int `json:"to"`
+	Increase int `json:"increase"`
+}
+
+// ImpactResponse compares the map with and without the closed bathrooms.
+// Changed lists the cells which still reach a bathroom, Unreachable the ones
+// which no longer do. The worst case distance is After.MaxDistance.
+type ImpactResponse struct {
+	Closed      []int         `json:"closed"`
+	Changed     []OwnerChange `json:"changed"`
+	Unreachable []Cell        `json:"unreachable"`
+	Before      CoverageStats `json:"before"`
+	After       CoverageStats `json:"after"`
+}
+
+// drop the sites of the given bathrooms
+func withoutSites(sites []buildingSite, closed map[int]bool) []buildingSite {
+	open := make([]buildingSite, 0, len(sites))
+	for _, site := range sites {
+		if !closed[site.id] {
+			open = append(open, site)
+		}
+	}
+	return open
+}
+
+// closureImpact floods the building with and without the closed bathrooms
+// and compares every cell
+func (b building) closureImpact(sites []buildingSite, closed map[int]bool) ImpactResponse {
+	labels, distances := b.flood(sites)
+	newLabels, newDistances := b.flood(withoutSites(sites, closed))
+
+	impact := ImpactResponse{
+		Changed:     make([]OwnerChange, 0),
+		Unreachable: make([]Cell, 0),
+		Before:      coverageStats(distances, b.floors),
+		After:       coverageStats(newDistances, b.floors),
+	}
+	for floor := range labels {
+		for x := range labels[floor] {
+			for y, label := range labels[floor][x] {
+				newLabel := newLabels[floor][x][y]
+				if label == newLabel {
+					continue
+				}
+				cell := Cell{floor, x, y}
+				if newLabel == 0 {
+					impact.Unreachable = append(impact.Unreachable, cell)
+					continue
+				}
+				increase := newDistances[floor][x][y] - distances[floor][x][y]
+				impact.Changed = append(impact.Changed, OwnerChange{cell, label, newLabel, increase})
+			}
+		}
+	}
+	return impact
+}
+
+// what changes when bathrooms close
+func impactHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var impactReq ImpactRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&impactReq); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if len(impactReq.Close) == 0 {
+		http.Error(w, "close must list at least one bathroom", http.StatusBadRequest)
+		return
+	}
+
+	bathroomMap, err := resolveMapRequest(impactReq.ID, BathroomMap{
+		Grid:       impactReq.Matrix,
+		Floors:     impactReq.Floors,
+		Connectors: impactReq.Connectors,
+		Bathrooms:  impactReq.Bathrooms,
+	})
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := newBuilding(bathroomMap.floorGrids(), bathroomMap.Connectors, impactReq.Costs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// every closed bathroom has to be drawn in the map
+	sites := b.findSites(bathroomMap.Bathrooms, nil)
+	present := make(map[int]bool)
+	for _, site := range sites {
+		present[site.id] = true
+	}
+	closed := make(map[int]bool)
+	for _, id := range impactReq.Close {
+		if !present[id] {
+			http.Error(w, fmt.Sprintf("Bathroom %d is not in the map", id), http.StatusBadRequest)
+			return
+		}
+		closed[id] = true
+	}
+
+	impact := b.closureImpact(sites, closed)
+	impact.Closed = impactReq.Close
+
+	jsonResponse, err := json.Marshal(impact)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
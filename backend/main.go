@@ -1,21 +1,79 @@
 package main
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const bathroomsDB = "bathroomsDB.json"
 
+// errMapNotFound is returned when no stored map has the requested ID
+var errMapNotFound = errors.New("BathroomMap not found")
+
+// Voronoi algorithms selectable through VoronoiRequest.Mode
+const (
+	// VoronoiModeSample is the original sampling approximation
+	VoronoiModeSample = "sample"
+	// VoronoiModeExact floods from every bathroom at once, see VoronoiExact
+	VoronoiModeExact = "exact"
+)
+
 // VoronoiRequest represents the JSON input structure.
 type VoronoiRequest struct {
 	Matrix [][]int `json:"matrix"`
+	Mode   string  `json:"mode"`
+	// Distances asks for a VoronoiDistanceResponse instead of the bare labels
+	Distances bool `json:"distances"`
+	// ID loads the grid and bathrooms of a stored map instead of Matrix
+	ID int `json:"ID"`
+	// Bathrooms describes the sites of Matrix so Filter can be applied to it
+	Bathrooms []Bathroom `json:"bathrooms"`
+	// Filter restricts the diagram to bathrooms matching the criteria
+	Filter *BathroomFilter `json:"filter"`
+	// Costs overrides the walking cost of cell codes, exact mode only
+	Costs CellCosts `json:"costs"`
+	// AccessibleOnly treats stairs and steps as walls and only counts
+	// accessible bathrooms
+	AccessibleOnly bool `json:"accessibleOnly"`
+	// Floors and Connectors describe a multi-floor building instead of Matrix
+	Floors     [][][]int   `json:"floors"`
+	Connectors []Connector `json:"connectors"`
+	// Weighting asks for a weighted diagram, WeightingMultiplicative or
+	// WeightingAdditive, exact mode only
+	Weighting string `json:"weighting"`
+	// Weights of the bathrooms by ID, bathrooms left out are not weighted.
+	// WeightsFromRatings takes them from the stored ratings of map ID instead.
+	Weights            map[int]float64 `json:"weights"`
+	WeightsFromRatings bool            `json:"weightsFromRatings"`
+	// At leaves out the bathrooms which are closed or out of service at that
+	// time, now when it is not set
+	At *time.Time `json:"at"`
+}
+
+// VoronoiDistanceResponse pairs the label matrix with the walking cost from
+// every cell to its nearest bathroom, which is the number of grid steps when
+// the map only uses open floor. Walls and cells that cannot reach a bathroom
+// have a distance of Unreachable.
+type VoronoiDistanceResponse struct {
+	Labels    [][]int `json:"labels"`
+	Distances [][]int `json:"distances"`
+}
+
+// VoronoiFloorsResponse is returned for multi-floor buildings, with one label
+// matrix per floor and, when asked for, one distance matrix per floor.
+type VoronoiFloorsResponse struct {
+	Labels    [][][]int `json:"labels"`
+	Distances [][][]int `json:"distances,omitempty"`
 }
 
 func voronoiHandler(w http.ResponseWriter, r *http.Request) {
@@ -34,15 +92,153 @@ func voronoiHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	bathroomMap, err := resolveMapRequest(voronoiReq.ID, BathroomMap{
+		Grid:       voronoiReq.Matrix,
+		Floors:     voronoiReq.Floors,
+		Connectors: voronoiReq.Connectors,
+		Bathrooms:  voronoiReq.Bathrooms,
+	})
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	at := time.Now()
+	if voronoiReq.At != nil {
+		at = *voronoiReq.At
+	}
+	floors, connectors := bathroomMap.availableFloors(at), bathroomMap.Connectors
+	if voronoiReq.AccessibleOnly {
+		floors, connectors, voronoiReq.Filter = applyAccessibleOnly(floors, connectors, voronoiReq.Filter)
+	}
+	b, err := newBuilding(floors, connectors, voronoiReq.Costs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Process the VoronoiRequest (replace this with your actual Voronoi algorithm implementation)
 	// Here, we simply print the received data for demonstration purposes.
-	fmt.Println("Received matrix:", voronoiReq.Matrix)
+	fmt.Println("Received matrix:", floors)
+
+	// weighted diagrams are only flooded exactly
+	if voronoiReq.Weighting != "" {
+		if voronoiReq.Mode != "" && voronoiReq.Mode != VoronoiModeExact {
+			http.Error(w, "Weighted diagrams require exact mode", http.StatusBadRequest)
+			return
+		}
+		sites := b.findSites(bathroomMap.Bathrooms, voronoiReq.Filter)
+		weights := voronoiReq.Weights
+		if voronoiReq.WeightsFromRatings {
+			// ratings belong to bathrooms of stored maps
+			if voronoiReq.ID == 0 {
+				http.Error(w, "Weights from ratings need the ID of a stored map", http.StatusBadRequest)
+				return
+			}
+			weights, err = ratingWeights(voronoiReq.Weighting, voronoiReq.ID, sites)
+			if err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := checkWeights(voronoiReq.Weighting, weights); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		labels, distances := b.weightedFlood(sites, voronoiReq.Weighting, weights)
+		var weightedResponse interface{}
+		if len(b.floors) > 1 || len(connectors) > 0 {
+			floorsResponse := VoronoiFloorsResponse{Labels: labels}
+			if voronoiReq.Distances {
+				floorsResponse.Distances = distances
+			}
+			weightedResponse = floorsResponse
+		} else if voronoiReq.Distances {
+			weightedResponse = VoronoiDistanceResponse{Labels: labels[0], Distances: distances[0]}
+		} else {
+			weightedResponse = labels[0]
+		}
+		jsonResponse, err := json.Marshal(weightedResponse)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+		return
+	}
+
+	// buildings with several floors are always flooded exactly
+	if len(b.floors) > 1 || len(connectors) > 0 {
+		if voronoiReq.Mode != "" && voronoiReq.Mode != VoronoiModeExact {
+			http.Error(w, "Multi-floor maps require exact mode", http.StatusBadRequest)
+			return
+		}
+		labels, distances := b.flood(b.findSites(bathroomMap.Bathrooms, voronoiReq.Filter))
+		floorsResponse := VoronoiFloorsResponse{Labels: labels}
+		if voronoiReq.Distances {
+			floorsResponse.Distances = distances
+		}
+		jsonResponse, err := json.Marshal(floorsResponse)
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+		return
+	}
+	matrix := b.floors[0]
 
 	// run some code to fish out the bathrooms (all points who are greater than 0)
-	bathroomVoronoi, _ := FindBathrooms(voronoiReq.Matrix)
+	bathroomVoronoi, _ := FindBathrooms(matrix)
+	if voronoiReq.Filter != nil {
+		bathroomVoronoi = filterSites(bathroomVoronoi, bathroomMap.Bathrooms, *voronoiReq.Filter)
+	}
+
+	// distances only exist for the exact diagram
+	if voronoiReq.Distances {
+		if voronoiReq.Mode != "" && voronoiReq.Mode != VoronoiModeExact {
+			http.Error(w, "Distances require exact mode", http.StatusBadRequest)
+			return
+		}
+		labels, distances := VoronoiDistances(matrix, bathroomVoronoi, voronoiReq.Costs)
+		jsonResponse, err := json.Marshal(VoronoiDistanceResponse{Labels: labels, Distances: distances})
+		if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(jsonResponse)
+		return
+	}
 
 	// create the voronoi output array
-	voronoiOutput := Voronoi(voronoiReq.Matrix, bathroomVoronoi)
+	var voronoiOutput [][]int
+	switch voronoiReq.Mode {
+	case "", VoronoiModeSample:
+		// the sampler always walks with the default cell costs
+		if len(voronoiReq.Costs) > 0 {
+			http.Error(w, "Custom costs require exact mode", http.StatusBadRequest)
+			return
+		}
+		voronoiOutput = Voronoi(matrix, bathroomVoronoi)
+	case VoronoiModeExact:
+		voronoiOutput = VoronoiExact(matrix, bathroomVoronoi, voronoiReq.Costs)
+	default:
+		http.Error(w, "Unknown voronoi mode", http.StatusBadRequest)
+		return
+	}
 
 	// create the response
 	jsonResponse, err := json.Marshal(voronoiOutput)
@@ -63,6 +259,8 @@ type Bathroom struct {
 	Gender           string `json:"gender"`
 	Accessible       bool   `json:"accessible"`
 	MenstrualProduct bool   `json:"menstrualProducts"`
+	// Hours is the opening schedule, bathrooms without one never close
+	Hours *OpeningHours `json:"hours,omitempty"`
 }
 
 // Coordinates represents the latitude and longitude of a location.
@@ -72,11 +270,18 @@ type Coordinates struct {
 }
 
 // BathroomMap represents the main structure for unmarshaling the JSON.
+// Multi-floor buildings list every floor in Floors, joined by Connectors, and
+// keep the first floor in Grid for clients that only draw one floor.
 type BathroomMap struct {
 	Name        string        `json:"name"`
 	Coordinates []Coordinates `json:"coordinates"`
 	Grid        [][]int       `json:"grid"`
 	Bathrooms   []Bathroom    `json:"bathrooms"`
+	Floors      [][][]int     `json:"floors,omitempty"`
+	Connectors  []Connector   `json:"connectors,omitempty"`
+	Retention   *Retention    `json:"retention,omitempty"`
+	// Statuses are set through /api/maps/{id}/status, writes ignore them
+	Statuses []BathroomStatus `json:"statuses,omitempty"`
 }
 
 type BathroomMapOutput struct {
@@ -84,9 +289,30 @@ type BathroomMapOutput struct {
 	Coordinates []Coordinates `json:"coordinates"`
 	Grid        [][]int       `json:"grid"`
 	Bathrooms   []Bathroom    `json:"bathrooms"`
+	Floors      [][][]int     `json:"floors,omitempty"`
+	Connectors  []Connector   `json:"connectors,omitempty"`
 	ID          int           `json:"ID"`
 	Time        time.Time     `json:"time"`
 	Delete      bool          `json:"delete"`
+	// Revision goes up by one on every update, see BathroomMapUpdate
+	Revision  int        `json:"revision"`
+	Retention *Retention `json:"retention,omitempty"`
+	// Pinned maps are kept whatever their retention says
+	Pinned bool `json:"pinned"`
+	// Author saved the current revision
+	Author string `json:"author,omitempty"`
+	// Owner is the account which created the map, empty for anonymous maps
+	Owner string `json:"owner,omitempty"`
+	// Statuses take bathrooms out of service for a while
+	Statuses []BathroomStatus `json:"statuses,omitempty"`
+}
+
+// every floor grid of the map, a single floor map only has Grid
+func (bathroomMap BathroomMap) floorGrids() [][][]int {
+	if len(bathroomMap.Floors) > 0 {
+		return bathroomMap.Floors
+	}
+	return [][][]int{bathroomMap.Grid}
 }
 
 func generateUniqueID() int {
@@ -100,23 +326,26 @@ func generateUniqueID() int {
 }
 
 func ConvertBathroomMapToOutput(bathroomMap BathroomMap) BathroomMapOutput {
+	// single floor clients still get something to draw
+	if len(bathroomMap.Grid) == 0 && len(bathroomMap.Floors) > 0 {
+		bathroomMap.Grid = bathroomMap.Floors[0]
+	}
 	bathroomMapOutput := BathroomMapOutput{
 		Name:        bathroomMap.Name,
 		ID:          generateUniqueID(),
 		Time:        time.Now(),
-		Delete:      true,
+		Delete:      bathroomMap.Retention == nil || bathroomMap.Retention.Policy != RetentionPermanent,
 		Coordinates: bathroomMap.Coordinates,
 		Grid:        bathroomMap.Grid,
 		Bathrooms:   bathroomMap.Bathrooms,
+		Floors:      bathroomMap.Floors,
+		Connectors:  bathroomMap.Connectors,
+		Revision:    1,
+		Retention:   bathroomMap.Retention,
 	}
 	return bathroomMapOutput
 }
 
-// check if more than one hour ago
-func isMoreThanOneHourAgo(t time.Time) bool {
-	return time.Now().Sub(t) > time.Hour
-}
-
 // check if more than one minute ago
 func isMoreThanOneMinuteAgo(t time.Time) bool {
 	return time.Now().Sub(t) > time.Minute
@@ -138,13 +367,29 @@ func bathroomWriteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
+	if bathroomMap.Retention != nil {
+		if err := bathroomMap.Retention.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := validateBathrooms(bathroomMap.Bathrooms); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	bathroomMapOutput := ConvertBathroomMapToOutput(bathroomMap)
+	// logged in callers own the maps they create, the author is never taken
+	// from the body so anonymous saves cannot pass as a user
+	bathroomMapOutput.Owner = caller(r)
+	bathroomMapOutput.Author = caller(r)
 
-	// Write the bathroomMap to the file
-	if err := writeBathroomMapToFile(bathroomMapOutput); err != nil {
+	// Write the bathroomMap to the store
+	if err := writeBathroomMap(bathroomMapOutput); err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	refreshThumbnail(bathroomMapOutput)
 
 	// turn bathroomMap back into JSON
 	jsonResponse, err := json.Marshal(bathroomMapOutput)
@@ -158,44 +403,10 @@ func bathroomWriteHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonResponse)
 }
 
-// write a new bathroom map to the file
-func writeBathroomMapToFile(bathroomMap BathroomMapOutput) error {
-	// Read existing data from file
-	file, err := os.ReadFile(bathroomsDB)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return err
-	}
-	// add time and delete to the bathroomMap
+// write a new bathroom map to the store
+func writeBathroomMap(bathroomMap BathroomMapOutput) error {
+	// add time to the bathroomMap
 	bathroomMap.Time = time.Now()
-	bathroomMap.Delete = true
-
-	// Unmarshal the JSON data into a slice of BathroomMap objects
-	var bathroomMaps []BathroomMapOutput
-	err = json.Unmarshal(file, &bathroomMaps)
-	if err != nil {
-		fmt.Println("Error Unmarshal JSON Write to File:", err)
-		return err
-	}
-
-	// Append the new bathroomMap to the JSON
-	bathroomMaps = append(bathroomMaps, bathroomMap)
-
-	// // Remove entries older than 5 minutes
-	// currentTime := time.Now()
-	// var updatedBathroomMaps []BathroomMapOutput
-	// for _, bm := range bathroomMaps {
-	// 	if currentTime.Sub(bm.CreatedAt) <= 5*time.Minute {
-	// 		updatedBathroomMaps = append(updatedBathroomMaps, bm)
-	// 	}
-	// }
-
-	// Convert the bathroomMaps to JSON
-	jsonData, err := json.Marshal(bathroomMaps)
-	if err != nil {
-		fmt.Println("Error:", err)
-		return err
-	}
 
 	// Print the parsed data
 	fmt.Printf("Name: %s\n", bathroomMap.Name)
@@ -216,9 +427,7 @@ func writeBathroomMapToFile(bathroomMap BathroomMapOutput) error {
 			bath.ID, bath.Name, bath.Gender, bath.Accessible, bath.MenstrualProduct)
 	}
 
-	// Write the new JSON to the file
-	err = os.WriteFile(bathroomsDB, jsonData, 0644)
-	if err != nil {
+	if err := mapStore.Create(bathroomMap); err != nil {
 		fmt.Println("Error:", err)
 		return err
 	}
@@ -227,8 +436,9 @@ func writeBathroomMapToFile(bathroomMap BathroomMapOutput) error {
 }
 
 type BathroomGet struct {
-	Name string `json:"name"`
-	ID   int    `json:"ID"`
+	Name      string `json:"name"`
+	ID        int    `json:"ID"`
+	Thumbnail string `json:"thumbnail,omitempty"`
 }
 
 // Converts BathroomMapOutput to BathroomGet
@@ -240,47 +450,30 @@ func ConvertOutputToGet(bathroomMap BathroomMapOutput) BathroomGet {
 	return bathroomGet
 }
 
-// Get BathroomMaps from the file
-func getBathroomMapsFromFile() ([]BathroomGet, error) {
-	// Read existing data from file
-	file, err := os.ReadFile(bathroomsDB)
+// Get BathroomMaps from the store, skipping the ones which expired but have not
+// been swept by the janitor yet
+func getBathroomMaps() ([]BathroomGet, error) {
+	bathroomOutputs, err := mapStore.List()
 	if err != nil {
 		fmt.Println("Error:", err)
 		return nil, err
 	}
-	// Unmarshal the JSON data into a slice of BathroomMap objects
-	var bathroomOutputs []BathroomMapOutput
-	err = json.Unmarshal(file, &bathroomOutputs)
-	if err != nil {
-		fmt.Println("Error Unmarshal JSON get Map:", err)
-		return nil, err
-	}
 
 	// transform the data into an array of BathroomGet Structs
 	var bathroomGets []BathroomGet
-	var updatedBathroomOutputs []BathroomMapOutput
+	now := time.Now()
 	for _, maps := range bathroomOutputs {
-		// fmt.Println(maps)
-		// fmt.Println(maps.time)
-		// fmt.Println(maps.delete)
-
-		// check if the bathroom is more than one hour old and delete is true
-		if isMoreThanOneHourAgo(maps.Time) && maps.Delete {
+		if maps.isExpired(now) {
 			continue
 		}
-		updatedBathroomOutputs = append(updatedBathroomOutputs, maps)
-		bathroomGets = append(bathroomGets, ConvertOutputToGet(maps))
-	}
-
-	// update the file with the new data
-	jsonData, err := json.MarshalIndent(updatedBathroomOutputs, "", " ")
-	if err != nil {
-		fmt.Println("Error Writing Back to JSON:", err)
-		return nil, err
+		bathroomGet := ConvertOutputToGet(maps)
+		if hasThumbnail(maps.ID) {
+			bathroomGet.Thumbnail = thumbnailURL(maps.ID, maps.Revision)
+		}
+		bathroomGets = append(bathroomGets, bathroomGet)
 	}
-	err = os.WriteFile(bathroomsDB, jsonData, 0644)
 
-	return bathroomGets, err
+	return bathroomGets, nil
 }
 
 // bathroom maps by both name and ID
@@ -291,7 +484,7 @@ func bathroomGetHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bathroomMaps, err := getBathroomMapsFromFile()
+	bathroomMaps, err := getBathroomMaps()
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -323,11 +516,16 @@ func bathroomGetByIDHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer r.Body.Close()
 
-	bathroomMap, err := getBathroomMapsByID(bathroomID.ID)
-	if err != nil {
+	bathroomMap, err := mapStore.Get(bathroomID.ID)
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	// only show the statuses which have not expired
+	bathroomMap.Statuses = activeStatuses(bathroomMap.Statuses, time.Now())
 
 	// turn bathroomMap back into JSON
 	jsonResponse, err := json.Marshal(bathroomMap)
@@ -341,31 +539,199 @@ func bathroomGetByIDHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(jsonResponse)
 }
 
-// get bathroom map by ID
-func getBathroomMapsByID(id int) (BathroomMapOutput, error) {
-	// Read existing data from file
-	file, err := os.ReadFile(bathroomsDB)
+// BathroomMapUpdate replaces the contents of the stored map with ID. Revision
+// is the revision the edit started from, if the map has been saved since then
+// the update is rejected with a conflict instead of overwriting that save.
+type BathroomMapUpdate struct {
+	BathroomMap
+	ID       int `json:"ID"`
+	Revision int `json:"revision"`
+}
+
+// update an existing bathroom map
+func bathroomUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow PUT requests
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var bathroomMapUpdate BathroomMapUpdate
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&bathroomMapUpdate); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// revisions start at 1, an edit must name the one it started from
+	if bathroomMapUpdate.Revision < 1 {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+	if bathroomMapUpdate.Retention != nil {
+		if err := bathroomMapUpdate.Retention.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := validateBathrooms(bathroomMapUpdate.Bathrooms); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	bathroomMapOutput, err := mapStore.Get(bathroomMapUpdate.ID)
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// keep the identity of the stored map and swap in the new contents
+	updated := ConvertBathroomMapToOutput(bathroomMapUpdate.BathroomMap)
+	updated.ID = bathroomMapOutput.ID
+	updated.Time = bathroomMapOutput.Time
+	updated.Revision = bathroomMapUpdate.Revision
+	updated.Pinned = bathroomMapOutput.Pinned
+	updated.Owner = bathroomMapOutput.Owner
+	updated.Statuses = bathroomMapOutput.Statuses
+	updated.Author = caller(r)
+	// the retention stays as it was unless the update sets a new one
+	if bathroomMapUpdate.Retention == nil {
+		updated.Retention = bathroomMapOutput.Retention
+		updated.Delete = bathroomMapOutput.Delete
+	}
+
+	err = mapStore.Update(updated)
+	if errors.Is(err, errRevisionConflict) {
+		http.Error(w, "Map was changed since it was loaded", http.StatusConflict)
+		return
+	} else if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	updated.Revision += 1
+	refreshThumbnail(updated)
+
+	jsonResponse, err := json.Marshal(updated)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return BathroomMapOutput{}, err
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// BathroomMapDelete names the map to delete and the revision it was last seen at
+type BathroomMapDelete struct {
+	ID       int `json:"ID"`
+	Revision int `json:"revision"`
+}
+
+// delete an existing bathroom map
+func bathroomDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow DELETE requests
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var bathroomMapDelete BathroomMapDelete
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&bathroomMapDelete); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if bathroomMapDelete.Revision < 1 {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
 	}
+	err := mapStore.Delete(bathroomMapDelete.ID, bathroomMapDelete.Revision)
+	if errors.Is(err, errRevisionConflict) {
+		http.Error(w, "Map was changed since it was loaded", http.StatusConflict)
+		return
+	} else if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	removeThumbnail(bathroomMapDelete.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
 
-	// Unmarshal the JSON data into a slice of BathroomMap objects
-	var bathroomMaps []BathroomMapOutput
-	err = json.Unmarshal(file, &bathroomMaps)
+// resolve the map a request works on, either the stored map with the given ID
+// or the one the request carries itself
+func resolveMapRequest(id int, bathroomMap BathroomMap) (BathroomMap, error) {
+	if id == 0 {
+		return bathroomMap, nil
+	}
+	bathroomMapOutput, err := mapStore.Get(id)
 	if err != nil {
-		fmt.Println("Error:", err)
-		return BathroomMapOutput{}, err
+		return BathroomMap{}, err
 	}
+	return ConvertOutputToMap(bathroomMapOutput), nil
+}
 
-	// find the bathroom map with the given ID
-	for _, bathroomMap := range bathroomMaps {
-		if bathroomMap.ID == id {
-			return bathroomMap, nil
-		}
+// Converts BathroomMapOutput back to the BathroomMap it was created from
+func ConvertOutputToMap(bathroomMapOutput BathroomMapOutput) BathroomMap {
+	return BathroomMap{
+		Name:        bathroomMapOutput.Name,
+		Coordinates: bathroomMapOutput.Coordinates,
+		Grid:        bathroomMapOutput.Grid,
+		Bathrooms:   bathroomMapOutput.Bathrooms,
+		Floors:      bathroomMapOutput.Floors,
+		Connectors:  bathroomMapOutput.Connectors,
+		Retention:   bathroomMapOutput.Retention,
+		Statuses:    bathroomMapOutput.Statuses,
+	}
+}
+
+// mapRouteHandler serves one route under /api/maps/{id}/ for the map with id
+type mapRouteHandler func(w http.ResponseWriter, r *http.Request, id int)
+
+// routes under /api/maps/{id}/ by their last path segment
+var mapRoutes = map[string]mapRouteHandler{
+	"analytics": mapAnalyticsHandler,
+	"geo":       mapGeoHandler,
+	"geojson":   mapGeoJSONHandler,
+	"png":       mapPNGHandler,
+	"svg":       mapSVGHandler,
+	"status":    mapStatusHandler,
+}
+
+// mapsHandler picks the handler for /api/maps/{id}/{route}
+func mapsHandler(w http.ResponseWriter, r *http.Request) {
+	idPart, route, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/api/maps/"), "/")
+	id, err := strconv.Atoi(idPart)
+	handler, ok := mapRoutes[route]
+	if err != nil || !ok {
+		http.NotFound(w, r)
+		return
 	}
+	handler(w, r, id)
+}
 
-	return BathroomMapOutput{}, errors.New("BathroomMap not found")
+// read an integer query parameter, falling back when it is not set
+func queryInt(r *http.Request, name string, fallback int) (int, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
 }
 
 // enableCORS is a middleware function to enable CORS for all origins
@@ -392,18 +758,88 @@ func enableCORS(handler http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// requireAdmin is a middleware function which only lets requests carrying the
+// admin token from BATHROOM_ADMIN_TOKEN through. Admin routes are closed when
+// no token is configured.
+func requireAdmin(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		adminToken := os.Getenv("BATHROOM_ADMIN_TOKEN")
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
 func main() {
+	migrate := flag.Bool("migrate", false, "copy every map in "+bathroomsDB+" into the configured store and exit")
+	flag.Parse()
+
+	// pick the store backend, the JSON file unless configured otherwise
+	store, err := openMapStore(os.Getenv("BATHROOM_STORE"), os.Getenv("BATHROOM_STORE_PATH"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+	mapStore = store
+
+	if *migrate {
+		migrated, err := migrateMaps(&jsonMapStore{path: bathroomsDB}, mapStore)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Migrated %d maps from %s", migrated, bathroomsDB)
+		return
+	}
+
+	userStore, err = openUserStore(mapStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sessionSecret, err = loadSessionSecret()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ratingStore, err = openRatingStore(mapStore)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// sweep expired maps in the background
+	go runJanitor(mapStore, janitorInterval)
+
 	// Define the endpoint and handler function
 	// http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 	// 	fmt.Fprint(w, "Welcome to the bathroom finder API!")
 	// })
-	http.HandleFunc("/api/voronoi", enableCORS(voronoiHandler))
-	http.HandleFunc("/api/bathroom/write", enableCORS(bathroomWriteHandler))
-	http.HandleFunc("/api/bathroom/maps/id", enableCORS(bathroomGetByIDHandler))
-	http.HandleFunc("/api/bathroom/maps", enableCORS(bathroomGetHandler))
+	http.HandleFunc("/api/voronoi", enableCORS(withUser(voronoiHandler)))
+	http.HandleFunc("/api/bathroom/write", enableCORS(withUser(bathroomWriteHandler)))
+	http.HandleFunc("/api/bathroom/maps/id", enableCORS(withUser(bathroomGetByIDHandler)))
+	http.HandleFunc("/api/bathroom/maps", enableCORS(withUser(bathroomGetHandler)))
+	http.HandleFunc("/api/bathroom/maps/update", enableCORS(withUser(bathroomUpdateHandler)))
+	http.HandleFunc("/api/bathroom/maps/delete", enableCORS(withUser(bathroomDeleteHandler)))
+	http.HandleFunc("/api/bathroom/maps/revisions", enableCORS(withUser(mapRevisionsHandler)))
+	http.HandleFunc("/api/bathroom/maps/revision", enableCORS(withUser(mapRevisionHandler)))
+	http.HandleFunc("/api/bathroom/maps/diff", enableCORS(withUser(mapDiffHandler)))
+	http.HandleFunc("/api/bathroom/maps/rollback", enableCORS(withUser(mapRollbackHandler)))
+	http.HandleFunc("/api/admin/maps/pin", enableCORS(requireAdmin(mapPinHandler)))
+	http.HandleFunc("/api/route", enableCORS(withUser(routeHandler)))
+	http.HandleFunc("/api/nearest", enableCORS(withUser(nearestHandler)))
+	http.HandleFunc("/api/bathroom/ratings", enableCORS(withUser(ratingSummaryHandler)))
+	http.HandleFunc("/api/bathroom/ratings/rate", enableCORS(withUser(requireUser(rateHandler))))
+	http.HandleFunc("/api/bathroom/ratings/comments", enableCORS(withUser(commentsHandler)))
+	http.HandleFunc("/api/maps/", enableCORS(withUser(mapsHandler)))
+	http.HandleFunc("/api/plan", enableCORS(withUser(planHandler)))
+	http.HandleFunc("/api/impact", enableCORS(withUser(impactHandler)))
+	http.HandleFunc("/api/users/register", enableCORS(registerHandler))
+	http.HandleFunc("/api/users/login", enableCORS(loginHandler))
+	http.HandleFunc("/api/users/logout", enableCORS(withUser(requireUser(logoutHandler))))
+	http.HandleFunc("/api/users/me", enableCORS(withUser(requireUser(currentUserHandler))))
 
 	// Specify the directory containing the files
-	dir := "./images/"
+	dir := imagesDir
 	// Create a file server handler for the specified directory
 	fileServer := http.FileServer(http.Dir(dir))
 	// Create a handler function to serve files with modified URLs
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// average walking speed in meters per second used for walking times
+const walkingSpeed = 1.4
+
+// NearestRequest asks for the nearest bathroom to a GPS position out of every
+// stored map which covers it. Floor picks the floor of multi-floor maps.
+type NearestRequest struct {
+	Position       Coordinates     `json:"position"`
+	Floor          int             `json:"floor"`
+	Filter         *BathroomFilter `json:"filter"`
+	AccessibleOnly bool            `json:"accessibleOnly"`
+	At             *time.Time      `json:"at"`
+}
+
+// NearestResponse is the nearest bathroom and the walk to it. Start is the
+// walkable cell the position was snapped to. Meters counts the walk from the
+// position to Start and along the path, WalkingTime is in seconds and slowed
+// down by stairs, doors and other costly cells on the path.
+type NearestResponse struct {
+	MapID       int           `json:"mapId"`
+	MapName     string        `json:"mapName"`
+	BathroomID  int           `json:"bathroomId"`
+	Bathroom    *Bathroom     `json:"bathroom,omitempty"`
+	Start       Cell          `json:"start"`
+	Path        []Cell        `json:"path"`
+	Positions   []Coordinates `json:"positions"`
+	Meters      float64       `json:"meters"`
+	WalkingTime float64       `json:"walkingTime"`
+}
+
+// snapToWalkable finds the walkable cell of a floor whose center is closest to
+// position, along with how far away it is in meters. ok is false when the
+// floor has no walkable cell.
+func (b building) snapToWalkable(transform geoTransform, floor int, position Coordinates) (floorPoint, float64, bool) {
+	var best floorPoint
+	bestMeters, found := 0.0, false
+	for x := range b.floors[floor] {
+		for y, cell := range b.floors[floor][x] {
+			if cell == CellWall {
+				continue
+			}
+			meters := metersBetween(position, transform.cellCenter(Point{x, y}))
+			if !found || meters < bestMeters {
+				best, bestMeters, found = floorPoint{floor, Point{x, y}}, meters, true
+			}
+		}
+	}
+	return best, bestMeters, found
+}
+
+// nearestInMap walks from position to the nearest bathroom of one stored map.
+// ok is false when the map does not cover position or no bathroom is reached.
+func nearestInMap(bathroomMapOutput BathroomMapOutput, nearestReq NearestRequest, at time.Time) (NearestResponse, bool) {
+	bathroomMap := ConvertOutputToMap(bathroomMapOutput)
+	transform, err := bathroomMap.geoTransform()
+	if err != nil {
+		return NearestResponse{}, false
+	}
+	if _, ok := transform.cellAt(nearestReq.Position); !ok {
+		return NearestResponse{}, false
+	}
+
+	floors, connectors, filter := bathroomMap.availableFloors(at), bathroomMap.Connectors, nearestReq.Filter
+	if nearestReq.AccessibleOnly {
+		floors, connectors, filter = applyAccessibleOnly(floors, connectors, filter)
+	}
+	b, err := newBuilding(floors, connectors, nil)
+	if err != nil || nearestReq.Floor < 0 || nearestReq.Floor >= len(b.floors) {
+		return NearestResponse{}, false
+	}
+	start, snapMeters, ok := b.snapToWalkable(transform, nearestReq.Floor, nearestReq.Position)
+	if !ok {
+		return NearestResponse{}, false
+	}
+	path, length := b.nearestPath(start, b.findSites(bathroomMap.Bathrooms, filter))
+	if path == nil {
+		return NearestResponse{}, false
+	}
+
+	positions := transform.cellPositions(path)
+	pathMeters := 0.0
+	for i := 1; i < len(positions); i++ {
+		pathMeters += metersBetween(positions[i-1], positions[i])
+	}
+	// spread the walking cost of the path evenly over its meters
+	slowdown := 1.0
+	if steps := len(path) - 1; steps > 0 {
+		slowdown = float64(length) / float64(steps)
+	}
+
+	end := path[len(path)-1]
+	bathroomID := b.floors[end.floor][end.point.x][end.point.y]
+	return NearestResponse{
+		MapID:       bathroomMapOutput.ID,
+		MapName:     bathroomMapOutput.Name,
+		BathroomID:  bathroomID,
+		Bathroom:    findBathroomByID(bathroomMap.Bathrooms, bathroomID),
+		Start:       Cell{start.floor, start.point.x, start.point.y},
+		Path:        floorPointsToCells(path),
+		Positions:   positions,
+		Meters:      snapMeters + pathMeters,
+		WalkingTime: (snapMeters + pathMeters*slowdown) / walkingSpeed,
+	}, true
+}
+
+// nearest bathroom to a GPS position across every stored map
+func nearestHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var nearestReq NearestRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&nearestReq); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	at := time.Now()
+	if nearestReq.At != nil {
+		at = *nearestReq.At
+	}
+
+	bathroomMaps, err := mapStore.List()
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	var best *NearestResponse
+	for _, bathroomMap := range bathroomMaps {
+		// expired maps are hidden from the gallery even before they are swept
+		if bathroomMap.isExpired(at) {
+			continue
+		}
+		nearest, ok := nearestInMap(bathroomMap, nearestReq, at)
+		if ok && (best == nil || nearest.Meters < best.Meters) {
+			best = &nearest
+		}
+	}
+	if best == nil {
+		http.Error(w, "No reachable bathroom near this position", http.StatusNotFound)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(best)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
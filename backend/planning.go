@@ -0,0 +1,313 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Objectives selectable through PlanRequest.Objective
+const (
+	// PlanKCenter places bathrooms to cut the worst walking distance
+	PlanKCenter = "k-center"
+	// PlanPMedian places bathrooms to cut the average walking distance
+	PlanPMedian = "p-median"
+)
+
+// most bathrooms a single plan may add
+const maxPlanCount = 20
+
+// p-median floods the map once per candidate and keeps every distance field,
+// so larger candidate sets are thinned out evenly to this many, and further
+// on large maps to keep at most maxMedianDistances distances in memory
+const (
+	maxMedianCandidates = 500
+	maxMedianDistances  = 4 << 20
+)
+
+// PlanRequest asks where Count new bathrooms should go in a map, either the
+// stored map with ID or the one given inline, placed on the earth by
+// Coordinates. Candidates restricts the new bathrooms to those cells, by
+// default any walkable cell without a bathroom.
+type PlanRequest struct {
+	ID          int           `json:"ID"`
+	Matrix      [][]int       `json:"matrix"`
+	Floors      [][][]int     `json:"floors"`
+	Connectors  []Connector   `json:"connectors"`
+	Bathrooms   []Bathroom    `json:"bathrooms"`
+	Coordinates []Coordinates `json:"coordinates"`
+	Count       int           `json:"count"`
+	Objective   string        `json:"objective"`
+	Candidates  []Cell        `json:"candidates"`
+	Costs       CellCosts     `json:"costs"`
+}
+
+// ProposedBathroom is a new bathroom and the cell it goes in. IDs continue
+// after the highest bathroom ID already in the map. Position is the center of
+// the cell when the map has coordinates.
+type ProposedBathroom struct {
+	ID       int          `json:"id"`
+	Cell     Cell         `json:"cell"`
+	Position *Coordinates `json:"position,omitempty"`
+}
+
+// PlanResponse lists the proposed bathrooms and the coverage of the map with
+// and without them
+type PlanResponse struct {
+	Objective string             `json:"objective"`
+	Proposed  []ProposedBathroom `json:"proposed"`
+	Before    CoverageStats      `json:"before"`
+	After     CoverageStats      `json:"after"`
+}
+
+// every walkable cell which does not hold a bathroom yet
+func (b building) candidateCells() []floorPoint {
+	var candidates []floorPoint
+	for floor, matrix := range b.floors {
+		for x := range matrix {
+			for y, cell := range matrix[x] {
+				if cell != CellWall && cell <= 0 {
+					candidates = append(candidates, floorPoint{floor, Point{x, y}})
+				}
+			}
+		}
+	}
+	return candidates
+}
+
+// the lowest ID above every bathroom in the building and its metadata
+func (b building) nextBathroomID(bathrooms []Bathroom) int {
+	next := 1
+	for _, matrix := range b.floors {
+		for x := range matrix {
+			for _, cell := range matrix[x] {
+				if cell >= next {
+					next = cell + 1
+				}
+			}
+		}
+	}
+	for _, bathroom := range bathrooms {
+		if bathroom.ID >= next {
+			next = bathroom.ID + 1
+		}
+	}
+	return next
+}
+
+// planKCenter greedily puts each new bathroom on the candidate farthest from
+// every bathroom so far, cells which reach no bathroom counting as farthest.
+// This is the farthest-first heuristic, within twice the best worst case when
+// any cell may be chosen. It stops early once every candidate has a bathroom.
+func (b building) planKCenter(sites []buildingSite, candidates []floorPoint, count int, nextID int) []buildingSite {
+	var proposed []buildingSite
+	for len(proposed) < count {
+		_, distances := b.flood(append(sites, proposed...))
+		best, bestDistance := -1, 0
+		for i, candidate := range candidates {
+			distance := distances[candidate.floor][candidate.point.x][candidate.point.y]
+			if distance == Unreachable {
+				distance = MaxInt
+			}
+			if distance > bestDistance {
+				best, bestDistance = i, distance
+			}
+		}
+		if best == -1 {
+			break
+		}
+		proposed = append(proposed, buildingSite{candidates[best], nextID + len(proposed)})
+	}
+	return proposed
+}
+
+// every walkable cell of the building
+func (b building) walkableCells() []floorPoint {
+	var cells []floorPoint
+	for floor, matrix := range b.floors {
+		for x := range matrix {
+			for y, cell := range matrix[x] {
+				if cell != CellWall {
+					cells = append(cells, floorPoint{floor, Point{x, y}})
+				}
+			}
+		}
+	}
+	return cells
+}
+
+// distances of a flood at the given cells only
+func pickDistances(distances [][][]int, cells []floorPoint) []int {
+	picked := make([]int, len(cells))
+	for i, cell := range cells {
+		picked[i] = distances[cell.floor][cell.point.x][cell.point.y]
+	}
+	return picked
+}
+
+// the nearer of two distances, either of which may be Unreachable
+func nearerDistance(a, b int) int {
+	if a == Unreachable || (b != Unreachable && b < a) {
+		return b
+	}
+	return a
+}
+
+// planPMedian greedily adds the candidate which leaves the fewest cells
+// without a bathroom and then the smallest total walking distance. Every
+// candidate is flooded once up front and its distances reused in every round.
+func (b building) planPMedian(sites []buildingSite, candidates []floorPoint, count int, nextID int) []buildingSite {
+	walkable := b.walkableCells()
+	limit := maxMedianCandidates
+	if len(walkable) > 0 && maxMedianDistances/len(walkable) < limit {
+		limit = maxMedianDistances / len(walkable)
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	// thin out large candidate sets evenly
+	if len(candidates) > limit {
+		thinned := make([]floorPoint, limit)
+		for i := range thinned {
+			thinned[i] = candidates[i*len(candidates)/limit]
+		}
+		candidates = thinned
+	}
+
+	fields := make([][]int, len(candidates))
+	for i, candidate := range candidates {
+		_, distances := b.flood([]buildingSite{{candidate, nextID}})
+		fields[i] = pickDistances(distances, walkable)
+	}
+	_, distances := b.flood(sites)
+	current := pickDistances(distances, walkable)
+
+	var proposed []buildingSite
+	chosen := make(map[floorPoint]bool)
+	for len(proposed) < count {
+		best := -1
+		bestUnreachable, bestTotal := 0, 0
+		for i, field := range fields {
+			if chosen[candidates[i]] {
+				continue
+			}
+			unreachable, total := 0, 0
+			for j, distance := range field {
+				if distance = nearerDistance(current[j], distance); distance == Unreachable {
+					unreachable += 1
+				} else {
+					total += distance
+				}
+			}
+			if best == -1 || unreachable < bestUnreachable || (unreachable == bestUnreachable && total < bestTotal) {
+				best, bestUnreachable, bestTotal = i, unreachable, total
+			}
+		}
+		if best == -1 {
+			break
+		}
+		chosen[candidates[best]] = true
+		for j, distance := range fields[best] {
+			current[j] = nearerDistance(current[j], distance)
+		}
+		proposed = append(proposed, buildingSite{candidates[best], nextID + len(proposed)})
+	}
+	return proposed
+}
+
+// suggest where new bathrooms should go
+func planHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var planReq PlanRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&planReq); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if planReq.Count < 1 || planReq.Count > maxPlanCount {
+		http.Error(w, fmt.Sprintf("count must be between 1 and %d", maxPlanCount), http.StatusBadRequest)
+		return
+	}
+	if planReq.Objective != PlanKCenter && planReq.Objective != PlanPMedian {
+		http.Error(w, "Unknown objective", http.StatusBadRequest)
+		return
+	}
+
+	bathroomMap, err := resolveMapRequest(planReq.ID, BathroomMap{
+		Grid:        planReq.Matrix,
+		Floors:      planReq.Floors,
+		Connectors:  planReq.Connectors,
+		Bathrooms:   planReq.Bathrooms,
+		Coordinates: planReq.Coordinates,
+	})
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := newBuilding(bathroomMap.floorGrids(), bathroomMap.Connectors, planReq.Costs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	candidates := b.candidateCells()
+	if planReq.Candidates != nil {
+		candidates = make([]floorPoint, 0, len(planReq.Candidates))
+		for _, cell := range planReq.Candidates {
+			candidate := b.cellPoint(cell)
+			if !b.walkable(candidate) || b.floors[candidate.floor][candidate.point.x][candidate.point.y] > 0 {
+				http.Error(w, "Candidates must be walkable cells without a bathroom", http.StatusBadRequest)
+				return
+			}
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	sites := b.findSites(bathroomMap.Bathrooms, nil)
+	nextID := b.nextBathroomID(bathroomMap.Bathrooms)
+	var proposed []buildingSite
+	if planReq.Objective == PlanKCenter {
+		proposed = b.planKCenter(sites, candidates, planReq.Count, nextID)
+	} else {
+		proposed = b.planPMedian(sites, candidates, planReq.Count, nextID)
+	}
+
+	_, before := b.flood(sites)
+	_, after := b.flood(append(sites, proposed...))
+	planResponse := PlanResponse{
+		Objective: planReq.Objective,
+		Proposed:  make([]ProposedBathroom, len(proposed)),
+		Before:    coverageStats(before, b.floors),
+		After:     coverageStats(after, b.floors),
+	}
+	for i, site := range proposed {
+		planResponse.Proposed[i] = ProposedBathroom{ID: site.id, Cell: Cell{site.at.floor, site.at.point.x, site.at.point.y}}
+		if transform, err := bathroomMap.geoTransform(); err == nil {
+			position := transform.cellCenter(site.at.point)
+			planResponse.Proposed[i].Position = &position
+		}
+	}
+
+	jsonResponse, err := json.Marshal(planResponse)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanBathrooms(t *testing.T) {
+	corridor := [][]int{{1, 0, 0, 0, 0, 0, 0, 0, 0}}
+	// the two cells right of the wall reach no bathroom
+	pocket := [][]int{{1, 0, 0, 0, 0, 0, -1, 0, 0}}
+
+	tests := []struct {
+		name      string
+		grid      [][]int
+		objective string
+		count     int
+		want      []Point
+	}{
+		{"k-center takes the far end", corridor, PlanKCenter, 1, []Point{{0, 8}}},
+		{"k-center fills the largest gaps", corridor, PlanKCenter, 2, []Point{{0, 8}, {0, 4}}},
+		{"p-median cuts the total walk, first of a tie", corridor, PlanPMedian, 1, []Point{{0, 5}}},
+		{"k-center reaches the pocket first", pocket, PlanKCenter, 1, []Point{{0, 7}}},
+		{"p-median reaches the pocket first", pocket, PlanPMedian, 2, []Point{{0, 7}, {0, 3}}},
+		{"stops once every cell has a bathroom", [][]int{{1, 0}}, PlanKCenter, 3, []Point{{0, 1}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := newBuilding([][][]int{test.grid}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sites, candidates := b.findSites(nil, nil), b.candidateCells()
+			nextID := b.nextBathroomID(nil)
+			var proposed []buildingSite
+			if test.objective == PlanKCenter {
+				proposed = b.planKCenter(sites, candidates, test.count, nextID)
+			} else {
+				proposed = b.planPMedian(sites, candidates, test.count, nextID)
+			}
+			var got []Point
+			for i, site := range proposed {
+				if site.id != nextID+i {
+					t.Errorf("bathroom %d has ID %d, want %d", i, site.id, nextID+i)
+				}
+				got = append(got, site.at.point)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("proposed %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// where the JSON store keeps ratings
+const ratingsDB = "ratingsDB.json"
+
+// scores go from one to five stars, like the buttons on the rate page
+const (
+	minScore = 1
+	maxScore = 5
+)
+
+// longest comment accepted with a rating
+const maxCommentLength = 2000
+
+// comments returned per page unless the request asks for another size
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Rating categories
+const (
+	RatingAccessibility     = "accessibility"
+	RatingCleanliness       = "cleanliness"
+	RatingMenstrualProducts = "menstrualProducts"
+	RatingOverall           = "overall"
+)
+
+// every rating category, in the order the rate page asks them
+var ratingCategories = []string{RatingAccessibility, RatingCleanliness, RatingMenstrualProducts, RatingOverall}
+
+// Scores holds one score per rating category
+type Scores struct {
+	Accessibility     int `json:"accessibility"`
+	Cleanliness       int `json:"cleanliness"`
+	MenstrualProducts int `json:"menstrualProducts"`
+	Overall           int `json:"overall"`
+}
+
+// every score keyed by its category
+func (scores Scores) byCategory() map[string]int {
+	return map[string]int{
+		RatingAccessibility:     scores.Accessibility,
+		RatingCleanliness:       scores.Cleanliness,
+		RatingMenstrualProducts: scores.MenstrualProducts,
+		RatingOverall:           scores.Overall,
+	}
+}
+
+func (scores Scores) validate() error {
+	byCategory := scores.byCategory()
+	for _, category := range ratingCategories {
+		if score := byCategory[category]; score < minScore || score > maxScore {
+			return fmt.Errorf("%s must be between %d and %d", category, minScore, maxScore)
+		}
+	}
+	return nil
+}
+
+// Rating is what one user thinks of one bathroom in a map. A user has at most
+// one rating per bathroom, rating it again replaces it.
+type Rating struct {
+	MapID      int       `json:"ID"`
+	BathroomID int       `json:"bathroomId"`
+	Username   string    `json:"username"`
+	Scores     Scores    `json:"scores"`
+	Comment    string    `json:"comment,omitempty"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+}
+
+// RatingStore persists ratings
+type RatingStore interface {
+	// PutRating stores a rating, replacing the one the same user gave the same
+	// bathroom before but keeping when that was first created
+	PutRating(rating Rating) (Rating, error)
+	// Ratings returns every rating of a bathroom
+	Ratings(mapID, bathroomID int) ([]Rating, error)
+}
+
+// ratingStore holds every rating, set up in main
+var ratingStore RatingStore
+
+// openRatingStore opens the rating store matching the map store, bolt keeps
+// ratings in the same database as the maps
+func openRatingStore(maps MapStore) (RatingStore, error) {
+	switch store := maps.(type) {
+	case *boltMapStore:
+		return openBoltRatingStore(store.db)
+	default:
+		return &jsonRatingStore{path: ratingsDB}, nil
+	}
+}
+
+// keep the first creation time of a rating which is being replaced
+func replaceRating(existing, rating Rating) Rating {
+	rating.Created = existing.Created
+	return rating
+}
+
+// jsonRatingStore keeps every rating in a single JSON array, locked and
+// written atomically like jsonMapStore
+type jsonRatingStore struct {
+	path string
+	mu   sync.RWMutex
+}
+
+// read every rating from the file, a missing file is an empty store
+func (store *jsonRatingStore) load() ([]Rating, error) {
+	file, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var ratings []Rating
+	if err := json.Unmarshal(file, &ratings); err != nil {
+		return nil, err
+	}
+	return ratings, nil
+}
+
+func (store *jsonRatingStore) PutRating(rating Rating) (Rating, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	ratings, err := store.load()
+	if err != nil {
+		return Rating{}, err
+	}
+
+	replaced := false
+	for i, existing := range ratings {
+		if existing.MapID == rating.MapID && existing.BathroomID == rating.BathroomID && existing.Username == rating.Username {
+			rating = replaceRating(existing, rating)
+			ratings[i] = rating
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		ratings = append(ratings, rating)
+	}
+
+	jsonData, err := json.MarshalIndent(ratings, "", " ")
+	if err != nil {
+		return Rating{}, err
+	}
+	return rating, writeFileAtomic(store.path, jsonData, 0644)
+}
+
+func (store *jsonRatingStore) Ratings(mapID, bathroomID int) ([]Rating, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	ratings, err := store.load()
+	if err != nil {
+		return nil, err
+	}
+
+	var bathroomRatings []Rating
+	for _, rating := range ratings {
+		if rating.MapID == mapID && rating.BathroomID == bathroomID {
+			bathroomRatings = append(bathroomRatings, rating)
+		}
+	}
+	return bathroomRatings, nil
+}
+
+// bucket holding one JSON encoded Rating per map, bathroom and user
+var ratingsBucket = []byte("ratings")
+
+// boltRatingStore keeps ratings in the bolt database of the map store
+type boltRatingStore struct {
+	db *bolt.DB
+}
+
+func openBoltRatingStore(db *bolt.DB) (*boltRatingStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(ratingsBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &boltRatingStore{db}, nil
+}
+
+// ratings of one bathroom share a key prefix, so they can be read with a
+// single cursor scan
+func ratingPrefix(mapID, bathroomID int) []byte {
+	return append(mapKey(mapID), mapKey(bathroomID)...)
+}
+
+func (store *boltRatingStore) PutRating(rating Rating) (Rating, error) {
+	key := append(ratingPrefix(rating.MapID, rating.BathroomID), rating.Username...)
+	err := store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ratingsBucket)
+		if value := bucket.Get(key); value != nil {
+			var existing Rating
+			if err := json.Unmarshal(value, &existing); err != nil {
+				return err
+			}
+			rating = replaceRating(existing, rating)
+		}
+		value, err := json.Marshal(rating)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, value)
+	})
+	return rating, err
+}
+
+func (store *boltRatingStore) Ratings(mapID, bathroomID int) ([]Rating, error) {
+	prefix := ratingPrefix(mapID, bathroomID)
+	var ratings []Rating
+	err := store.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(ratingsBucket).Cursor()
+		for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			var rating Rating
+			if err := json.Unmarshal(value, &rating); err != nil {
+				return err
+			}
+			ratings = append(ratings, rating)
+		}
+		return nil
+	})
+	return ratings, err
+}
+
+// CategoryStats sums up the scores of one category. Distribution counts the
+// ratings giving each score from one to five.
+type CategoryStats struct {
+	Mean         float64     `json:"mean"`
+	Count        int         `json:"count"`
+	Distribution map[int]int `json:"distribution"`
+}
+
+// RatingSummary sums up every rating of a bathroom
+type RatingSummary struct {
+	MapID      int                      `json:"ID"`
+	BathroomID int                      `json:"bathroomId"`
+	Count      int                      `json:"count"`
+	Categories map[string]CategoryStats `json:"categories"`
+}
+
+// summarizeRatings works out the mean and distribution of every category
+func summarizeRatings(mapID, bathroomID int, ratings []Rating) RatingSummary {
+	summary := RatingSummary{
+		MapID:      mapID,
+		BathroomID: bathroomID,
+		Count:      len(ratings),
+		Categories: make(map[string]CategoryStats),
+	}
+
+	totals := make(map[string]int)
+	for _, category := range ratingCategories {
+		distribution := make(map[int]int)
+		for score := minScore; score <= maxScore; score++ {
+			distribution[score] = 0
+		}
+		summary.Categories[category] = CategoryStats{Distribution: distribution}
+	}
+	for _, rating := range ratings {
+		for category, score := range rating.Scores.byCategory() {
+			stats := summary.Categories[category]
+			stats.Count += 1
+			stats.Distribution[score] += 1
+			summary.Categories[category] = stats
+			totals[category] += score
+		}
+	}
+	for category, stats := range summary.Categories {
+		if stats.Count > 0 {
+			stats.Mean = float64(totals[category]) / float64(stats.Count)
+			summary.Categories[category] = stats
+		}
+	}
+	return summary
+}
+
+// Comment is the text left with a rating
+type Comment struct {
+	Username string    `json:"username"`
+	Text     string    `json:"text"`
+	Overall  int       `json:"overall"`
+	Updated  time.Time `json:"updated"`
+}
+
+// CommentPage is one page of the comments on a bathroom, newest first
+type CommentPage struct {
+	Comments []Comment `json:"comments"`
+	Page     int       `json:"page"`
+	PageSize int       `json:"pageSize"`
+	Total    int       `json:"total"`
+}
+
+// pageComments collects the ratings which carry a comment, newest first, and
+// cuts out one page. Pages count from one.
+func pageComments(ratings []Rating, page, pageSize int) CommentPage {
+	comments := make([]Comment, 0)
+	for _, rating := range ratings {
+		if rating.Comment != "" {
+			comments = append(comments, Comment{rating.Username, rating.Comment, rating.Scores.Overall, rating.Updated})
+		}
+	}
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].Updated.After(comments[j].Updated)
+	})
+
+	commentPage := CommentPage{Comments: make([]Comment, 0), Page: page, PageSize: pageSize, Total: len(comments)}
+	// compare page counts rather than offsets, which overflow for huge pages
+	if page-1 < (len(comments)+pageSize-1)/pageSize {
+		start := (page - 1) * pageSize
+		end := start + pageSize
+		if end > len(comments) {
+			end = len(comments)
+		}
+		commentPage.Comments = comments[start:end]
+	}
+	return commentPage
+}
+
+// RatingRequest rates a bathroom in a stored map
+type RatingRequest struct {
+	ID         int    `json:"ID"`
+	BathroomID int    `json:"bathroomId"`
+	Scores     Scores `json:"scores"`
+	Comment    string `json:"comment"`
+}
+
+// BathroomRef names a bathroom in a stored map, and a page of its comments
+// where needed
+type BathroomRef struct {
+	ID         int `json:"ID"`
+	BathroomID int `json:"bathroomId"`
+	Page       int `json:"page"`
+	PageSize   int `json:"pageSize"`
+}
+
+// check the bathroom exists in the stored map
+func checkBathroomRef(w http.ResponseWriter, mapID, bathroomID int) bool {
+	bathroomMap, err := mapStore.Get(mapID)
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return false
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return false
+	}
+	if findBathroomByID(bathroomMap.Bathrooms, bathroomID) == nil {
+		http.Error(w, "Bathroom not found", http.StatusNotFound)
+		return false
+	}
+	return true
+}
+
+// rate a bathroom as the logged in caller, replacing their earlier rating
+func rateHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var ratingReq RatingRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&ratingReq); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := ratingReq.Scores.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(ratingReq.Comment) > maxCommentLength {
+		http.Error(w, fmt.Sprintf("Comment must be at most %d characters", maxCommentLength), http.StatusBadRequest)
+		return
+	}
+	if !checkBathroomRef(w, ratingReq.ID, ratingReq.BathroomID) {
+		return
+	}
+
+	now := time.Now()
+	rating, err := ratingStore.PutRating(Rating{
+		MapID:      ratingReq.ID,
+		BathroomID: ratingReq.BathroomID,
+		Username:   caller(r),
+		Scores:     ratingReq.Scores,
+		Comment:    ratingReq.Comment,
+		Created:    now,
+		Updated:    now,
+	})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(rating)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// aggregate scores of a bathroom
+func ratingSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var bathroomRef BathroomRef
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&bathroomRef); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if !checkBathroomRef(w, bathroomRef.ID, bathroomRef.BathroomID) {
+		return
+	}
+	ratings, err := ratingStore.Ratings(bathroomRef.ID, bathroomRef.BathroomID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(summarizeRatings(bathroomRef.ID, bathroomRef.BathroomID, ratings))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// one page of the comments on a bathroom
+func commentsHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var bathroomRef BathroomRef
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&bathroomRef); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if bathroomRef.Page == 0 {
+		bathroomRef.Page = 1
+	}
+	if bathroomRef.PageSize == 0 {
+		bathroomRef.PageSize = defaultPageSize
+	}
+	if bathroomRef.Page < 1 || bathroomRef.PageSize < 1 || bathroomRef.PageSize > maxPageSize {
+		http.Error(w, fmt.Sprintf("page must be at least 1 and pageSize between 1 and %d", maxPageSize), http.StatusBadRequest)
+		return
+	}
+
+	if !checkBathroomRef(w, bathroomRef.ID, bathroomRef.BathroomID) {
+		return
+	}
+	ratings, err := ratingStore.Ratings(bathroomRef.ID, bathroomRef.BathroomID)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(pageComments(ratings, bathroomRef.Page, bathroomRef.PageSize))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
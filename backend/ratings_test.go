@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPageComments(t *testing.T) {
+	now := time.Now()
+	var ratings []Rating
+	for i := 0; i < 5; i++ {
+		ratings = append(ratings, Rating{Username: string(rune('a' + i)), Comment: "comment", Updated: now.Add(time.Duration(i) * time.Minute)})
+	}
+	// ratings without a comment are left out
+	ratings = append(ratings, Rating{Username: "silent", Updated: now.Add(time.Hour)})
+
+	tests := []struct {
+		name     string
+		page     int
+		pageSize int
+		want     []string
+	}{
+		{"first page newest first", 1, 2, []string{"e", "d"}},
+		{"last partial page", 3, 2, []string{"a"}},
+		{"past the end", 4, 2, []string{}},
+		{"huge page", 100000000000000000, 8, []string{}},
+		{"largest page", math.MaxInt, 50, []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			commentPage := pageComments(ratings, test.page, test.pageSize)
+			if commentPage.Total != 5 {
+				t.Errorf("total = %d, want 5", commentPage.Total)
+			}
+			if len(commentPage.Comments) != len(test.want) {
+				t.Fatalf("got %d comments, want %d", len(commentPage.Comments), len(test.want))
+			}
+			for i, comment := range commentPage.Comments {
+				if comment.Username != test.want[i] {
+					t.Errorf("comment %d by %q, want %q", i, comment.Username, test.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// pixels per cell of a rendered map unless the request sets its own
+const defaultRenderCellSize = 20
+
+// largest cell size and image a render may ask for
+const (
+	maxRenderCellSize = 64
+	maxRenderPixels   = 4096 * 4096
+)
+
+// legend rows are this many pixels high whatever the cell size
+const legendRowHeight = 20
+
+var (
+	wallColor       = color.RGBA{0, 0, 0, 255}
+	backgroundColor = color.RGBA{255, 255, 255, 255}
+	closedColor     = color.RGBA{128, 128, 128, 255}
+	labelColor      = color.RGBA{255, 255, 255, 255}
+	legendTextColor = color.RGBA{0, 0, 0, 255}
+)
+
+// diagram is one floor of a map and its Voronoi labels, ready to draw
+type diagram struct {
+	grid      [][]int
+	labels    [][]int
+	bathrooms []Bathroom
+	ids       []int
+	colors    map[int]color.RGBA
+	closed    map[int]bool
+}
+
+// newDiagram floods a floor of the map. Bathrooms in closed keep their marker
+// but own no region.
+func newDiagram(bathroomMap BathroomMap, floor int, closed map[int]bool) (diagram, error) {
+	floors := bathroomMap.floorGrids()
+	if floor < 0 || floor >= len(floors) {
+		return diagram{}, errors.New("floor is not in the map")
+	}
+	b, err := newBuilding(bathroomMap.floorsWithout(closed), bathroomMap.Connectors, nil)
+	if err != nil {
+		return diagram{}, err
+	}
+	labels, _ := b.flood(b.findSites(bathroomMap.Bathrooms, nil))
+
+	d := diagram{
+		grid:      floors[floor],
+		labels:    labels[floor],
+		bathrooms: bathroomMap.Bathrooms,
+		colors:    make(map[int]color.RGBA),
+		closed:    closed,
+	}
+	// regions can be owned by a bathroom on another floor, so every floor
+	// counts and a bathroom has the same color on each of them
+	for _, grid := range floors {
+		for x := range grid {
+			for _, cell := range grid[x] {
+				if _, ok := d.colors[cell]; cell > 0 && !ok {
+					d.colors[cell] = color.RGBA{}
+					d.ids = append(d.ids, cell)
+				}
+			}
+		}
+	}
+	sort.Ints(d.ids)
+	// spread the hues evenly like the viewer does
+	for i, id := range d.ids {
+		d.colors[id] = hslColor(float64(i)/float64(len(d.ids)), 0.8, 0.6)
+	}
+	return d, nil
+}
+
+func (d diagram) rows() int {
+	return len(d.grid)
+}
+
+func (d diagram) cols() int {
+	if len(d.grid) == 0 {
+		return 0
+	}
+	return len(d.grid[0])
+}
+
+// fill color of a cell, regions are a lighter shade of their bathroom
+func (d diagram) cellColor(x, y int) color.RGBA {
+	if d.grid[x][y] == CellWall {
+		return wallColor
+	}
+	if id := d.grid[x][y]; id > 0 {
+		if d.closed[id] {
+			return closedColor
+		}
+		return d.colors[id]
+	}
+	if id := d.labels[x][y]; id > 0 {
+		return mixColors(d.colors[id], backgroundColor, 0.5)
+	}
+	return backgroundColor
+}
+
+// the bathroom cells to put a marker on
+func (d diagram) markers() []Point {
+	var markers []Point
+	for x := range d.grid {
+		for y, cell := range d.grid[x] {
+			if cell > 0 {
+				markers = append(markers, Point{x, y})
+			}
+		}
+	}
+	return markers
+}
+
+// legend text of a bathroom, its ID followed by its name when it has one
+func (d diagram) legendLabel(id int) string {
+	label := strconv.Itoa(id)
+	if bathroom := findBathroomByID(d.bathrooms, id); bathroom != nil && bathroom.Name != "" {
+		label += " " + bathroom.Name
+	}
+	return label
+}
+
+// hslColor converts hue, saturation and lightness, all from 0 to 1
+func hslColor(h, s, l float64) color.RGBA {
+	chroma := (1 - math.Abs(2*l-1)) * s
+	h6 := h * 6
+	second := chroma * (1 - math.Abs(math.Mod(h6, 2)-1))
+	var r, g, b float64
+	switch int(h6) % 6 {
+	case 0:
+		r, g = chroma, second
+	case 1:
+		r, g = second, chroma
+	case 2:
+		g, b = chroma, second
+	case 3:
+		g, b = second, chroma
+	case 4:
+		r, b = second, chroma
+	case 5:
+		r, b = chroma, second
+	}
+	m := l - chroma/2
+	return color.RGBA{uint8(math.Round((r + m) * 255)), uint8(math.Round((g + m) * 255)), uint8(math.Round((b + m) * 255)), 255}
+}
+
+// mixColors blends a with b, weight being the share of a
+func mixColors(a, b color.RGBA, weight float64) color.RGBA {
+	mix := func(p, q uint8) uint8 {
+		return uint8(math.Round(float64(p)*weight + float64(q)*(1-weight)))
+	}
+	return color.RGBA{mix(a.R, b.R), mix(a.G, b.G), mix(a.B, b.B), 255}
+}
+
+// darker shade of c for markers
+func darken(c color.RGBA) color.RGBA {
+	return mixColors(c, wallColor, 0.6)
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// digitGlyphs are 3 by 5 pixel digits, one row of three bits per byte. The
+// standard library has no fonts, so PNG labels only show bathroom IDs.
+var digitGlyphs = [10][5]uint8{
+	{7, 5, 5, 5, 7},
+	{2, 6, 2, 2, 7},
+	{7, 1, 7, 4, 7},
+	{7, 1, 7, 1, 7},
+	{5, 5, 7, 1, 1},
+	{7, 4, 7, 1, 7},
+	{7, 4, 7, 5, 7},
+	{7, 1, 1, 1, 1},
+	{7, 5, 7, 5, 7},
+	{7, 5, 7, 1, 7},
+}
+
+// width of a number drawn with drawNumber at scale
+func numberWidth(n int, scale int) int {
+	digits := len(strconv.Itoa(n))
+	return (digits*4 - 1) * scale
+}
+
+// drawNumber draws n with its top left corner at (left, top), every glyph pixel
+// scale pixels wide
+func drawNumber(img *image.RGBA, n int, left, top, scale int, c color.RGBA) {
+	for i, digit := range strconv.Itoa(n) {
+		glyph := digitGlyphs[digit-'0']
+		for row, bits := range glyph {
+			for col := 0; col < 3; col++ {
+				if bits&(4>>col) == 0 {
+					continue
+				}
+				x, y := left+(i*4+col)*scale, top+row*scale
+				fillRect(img, image.Rect(x, y, x+scale, y+scale), c)
+			}
+		}
+	}
+}
+
+func fillRect(img *image.RGBA, rect image.Rectangle, c color.RGBA) {
+	rect = rect.Intersect(img.Bounds())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+func fillCircle(img *image.RGBA, cx, cy, radius float64, c color.RGBA) {
+	bounds := image.Rect(int(cx-radius), int(cy-radius), int(cx+radius)+1, int(cy+radius)+1).Intersect(img.Bounds())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			if dx*dx+dy*dy <= radius*radius {
+				img.SetRGBA(x, y, c)
+			}
+		}
+	}
+}
+
+// size of the legend next to the grid, nothing without a legend
+func (d diagram) legendSize(legend bool, labelWidth func(id int) int) (int, int) {
+	if !legend || len(d.ids) == 0 {
+		return 0, 0
+	}
+	width := 0
+	for _, id := range d.ids {
+		if w := labelWidth(id); w > width {
+			width = w
+		}
+	}
+	return legendRowHeight + width + legendRowHeight/2, (len(d.ids) + 1) * legendRowHeight
+}
+
+// renderPNG draws the diagram with cellSize pixels per cell and an optional
+// legend on the right
+func (d diagram) renderPNG(cellSize int, legend bool) *image.RGBA {
+	legendWidth, legendHeight := d.legendSize(legend, func(id int) int {
+		return numberWidth(id, 2)
+	})
+	width, height := d.cols()*cellSize+legendWidth, d.rows()*cellSize
+	if legendHeight > height {
+		height = legendHeight
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(img, img.Bounds(), backgroundColor)
+	for x := range d.grid {
+		for y := range d.grid[x] {
+			fillRect(img, image.Rect(y*cellSize, x*cellSize, (y+1)*cellSize, (x+1)*cellSize), d.cellColor(x, y))
+		}
+	}
+
+	for _, marker := range d.markers() {
+		id := d.grid[marker.x][marker.y]
+		cx, cy := (float64(marker.y)+0.5)*float64(cellSize), (float64(marker.x)+0.5)*float64(cellSize)
+		fill := darken(d.colors[id])
+		if d.closed[id] {
+			fill = darken(closedColor)
+		}
+		fillCircle(img, cx, cy, float64(cellSize)*0.45, fill)
+		// labels need at least one pixel per glyph pixel with a margin
+		if scale := cellSize / 10; scale > 0 {
+			drawNumber(img, id, int(cx)-numberWidth(id, scale)/2, int(cy)-5*scale/2, scale, labelColor)
+		}
+	}
+
+	if legendWidth > 0 {
+		left := d.cols()*cellSize + legendRowHeight/2
+		for i, id := range d.ids {
+			top := (i + 1) * legendRowHeight
+			swatch := legendRowHeight * 3 / 4
+			fillRect(img, image.Rect(left, top-swatch, left+swatch, top), d.colors[id])
+			drawNumber(img, id, left+legendRowHeight, top-swatch+(swatch-10)/2, 2, legendTextColor)
+		}
+	}
+	return img
+}
+
+// renderSVG draws the same picture as renderPNG as SVG, where the legend also
+// shows bathroom names
+func (d diagram) renderSVG(cellSize int, legend bool) []byte {
+	// rough width of legend text in a 12px sans serif font
+	legendWidth, legendHeight := d.legendSize(legend, func(id int) int {
+		return len(d.legendLabel(id)) * 7
+	})
+	width, height := d.cols()*cellSize+legendWidth, d.rows()*cellSize
+	if legendHeight > height {
+		height = legendHeight
+	}
+
+	var svg bytes.Buffer
+	fmt.Fprintf(&svg, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", width, height, width, height)
+	fmt.Fprintf(&svg, `<rect width="%d" height="%d" fill="%s"/>`+"\n", width, height, hexColor(backgroundColor))
+	for x := range d.grid {
+		for y := range d.grid[x] {
+			fill := d.cellColor(x, y)
+			if fill == backgroundColor {
+				continue
+			}
+			fmt.Fprintf(&svg, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", y*cellSize, x*cellSize, cellSize, cellSize, hexColor(fill))
+		}
+	}
+
+	for _, marker := range d.markers() {
+		id := d.grid[marker.x][marker.y]
+		cx, cy := (float64(marker.y)+0.5)*float64(cellSize), (float64(marker.x)+0.5)*float64(cellSize)
+		fill := darken(d.colors[id])
+		if d.closed[id] {
+			fill = darken(closedColor)
+		}
+		fmt.Fprintf(&svg, `<circle cx="%g" cy="%g" r="%g" fill="%s"/>`+"\n", cx, cy, float64(cellSize)*0.45, hexColor(fill))
+		fmt.Fprintf(&svg, `<text x="%g" y="%g" font-family="sans-serif" font-size="%g" fill="%s" text-anchor="middle" dominant-baseline="central">%d</text>`+"\n", cx, cy, float64(cellSize)*0.5, hexColor(labelColor), id)
+	}
+
+	if legendWidth > 0 {
+		left := d.cols()*cellSize + legendRowHeight/2
+		for i, id := range d.ids {
+			top := (i + 1) * legendRowHeight
+			swatch := legendRowHeight * 3 / 4
+			fmt.Fprintf(&svg, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", left, top-swatch, swatch, swatch, hexColor(d.colors[id]))
+			fmt.Fprintf(&svg, `<text x="%d" y="%d" font-family="sans-serif" font-size="12" fill="%s">`, left+legendRowHeight, top-swatch/4, hexColor(legendTextColor))
+			xml.EscapeText(&svg, []byte(d.legendLabel(id)))
+			svg.WriteString("</text>\n")
+		}
+	}
+	svg.WriteString("</svg>\n")
+	return svg.Bytes()
+}
+
+// renderRequest reads the stored map and render options of a render route,
+// writing the error response itself when it fails
+func renderRequest(w http.ResponseWriter, r *http.Request, id int) (diagram, int, bool, bool) {
+	//Allow only GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return diagram{}, 0, false, false
+	}
+
+	cellSize, err := queryInt(r, "cell", defaultRenderCellSize)
+	if err != nil || cellSize < 1 || cellSize > maxRenderCellSize {
+		http.Error(w, fmt.Sprintf("cell must be between 1 and %d", maxRenderCellSize), http.StatusBadRequest)
+		return diagram{}, 0, false, false
+	}
+	floor, err := queryInt(r, "floor", 0)
+	if err != nil {
+		http.Error(w, "floor must be an integer", http.StatusBadRequest)
+		return diagram{}, 0, false, false
+	}
+	legend := false
+	if value := r.URL.Query().Get("legend"); value != "" {
+		legend, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, "legend must be true or false", http.StatusBadRequest)
+			return diagram{}, 0, false, false
+		}
+	}
+
+	bathroomMapOutput, err := mapStore.Get(id)
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return diagram{}, 0, false, false
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return diagram{}, 0, false, false
+	}
+
+	bathroomMap := ConvertOutputToMap(bathroomMapOutput)
+	d, err := newDiagram(bathroomMap, floor, bathroomMap.unavailableAt(time.Now()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return diagram{}, 0, false, false
+	}
+	if d.rows()*d.cols()*cellSize*cellSize > maxRenderPixels {
+		http.Error(w, "Image would be too large, use a smaller cell size", http.StatusBadRequest)
+		return diagram{}, 0, false, false
+	}
+	return d, cellSize, legend, true
+}
+
+// stored map as a PNG, GET /api/maps/{id}/png with optional cell, floor and
+// legend query parameters
+func mapPNGHandler(w http.ResponseWriter, r *http.Request, id int) {
+	d, cellSize, legend, ok := renderRequest(w, r, id)
+	if !ok {
+		return
+	}
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, d.renderPNG(cellSize, legend)); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.WriteHeader(http.StatusOK)
+	w.Write(encoded.Bytes())
+}
+
+// stored map as an SVG, GET /api/maps/{id}/svg with the same query parameters
+// as the PNG
+func mapSVGHandler(w http.ResponseWriter, r *http.Request, id int) {
+	d, cellSize, legend, ok := renderRequest(w, r, id)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.WriteHeader(http.StatusOK)
+	w.Write(d.renderSVG(cellSize, legend))
+}
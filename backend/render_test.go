@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestNewDiagramColorsRegionsFromOtherFloors(t *testing.T) {
+	// floor 1 has no bathroom of its own and is reached by an elevator
+	bathroomMap := BathroomMap{
+		Floors: [][][]int{
+			{{1, 0}},
+			{{0, 0}},
+		},
+		Bathrooms:  []Bathroom{{ID: 1}},
+		Connectors: []Connector{{Kind: ConnectorElevator, From: Cell{0, 0, 1}, To: Cell{1, 0, 0}}},
+	}
+	d, err := newDiagram(bathroomMap, 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.labels[0][0] != 1 {
+		t.Fatalf("label = %d, want 1", d.labels[0][0])
+	}
+	if len(d.ids) != 1 || d.ids[0] != 1 {
+		t.Errorf("ids = %v, want [1]", d.ids)
+	}
+	if got := d.cellColor(0, 0); got == closedColor || got == backgroundColor {
+		t.Errorf("region of bathroom 1 is drawn %v", got)
+	}
+}
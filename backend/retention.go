@@ -0,0 +1,175 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Retention policies for stored maps
+const (
+	// RetentionPermanent maps are never swept
+	RetentionPermanent = "permanent"
+	// RetentionExpires maps are swept once ExpiresAt has passed
+	RetentionExpires = "expires"
+	// RetentionDraft maps are swept TTLSeconds after they were created
+	RetentionDraft = "draft"
+)
+
+// how long a draft lives when it does not set a TTL, and how long maps saved
+// before retention policies existed lived
+const defaultDraftTTL = time.Hour
+
+// how often the janitor looks for expired maps
+const janitorInterval = time.Minute
+
+// Retention decides when a stored map is swept away. Maps without a retention
+// fall back to the old Delete flag, which is a one hour draft.
+type Retention struct {
+	Policy     string     `json:"policy"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty"`
+	TTLSeconds int        `json:"ttlSeconds,omitempty"`
+}
+
+func (retention Retention) validate() error {
+	switch retention.Policy {
+	case RetentionPermanent:
+	case RetentionExpires:
+		if retention.ExpiresAt == nil {
+			return errors.New("expires retention needs expiresAt")
+		}
+	case RetentionDraft:
+		if retention.TTLSeconds < 0 {
+			return errors.New("draft ttlSeconds cannot be negative")
+		}
+	default:
+		return fmt.Errorf("unknown retention policy %q", retention.Policy)
+	}
+	return nil
+}
+
+// expiresAt returns when the map should be swept, or false if it is kept
+// forever. Pinned maps are always kept.
+func (bathroomMap BathroomMapOutput) expiresAt() (time.Time, bool) {
+	if bathroomMap.Pinned {
+		return time.Time{}, false
+	}
+	if bathroomMap.Retention == nil {
+		if bathroomMap.Delete {
+			return bathroomMap.Time.Add(defaultDraftTTL), true
+		}
+		return time.Time{}, false
+	}
+
+	switch bathroomMap.Retention.Policy {
+	case RetentionExpires:
+		return *bathroomMap.Retention.ExpiresAt, true
+	case RetentionDraft:
+		ttl := defaultDraftTTL
+		if bathroomMap.Retention.TTLSeconds > 0 {
+			ttl = time.Duration(bathroomMap.Retention.TTLSeconds) * time.Second
+		}
+		return bathroomMap.Time.Add(ttl), true
+	}
+	return time.Time{}, false
+}
+
+// check if the map should have been swept by now
+func (bathroomMap BathroomMapOutput) isExpired(now time.Time) bool {
+	expiresAt, expires := bathroomMap.expiresAt()
+	return expires && now.After(expiresAt)
+}
+
+// sweepExpiredMaps deletes every map which expired before now and returns how
+// many were removed. A map that changes while it is being swept is left for
+// the next sweep.
+func sweepExpiredMaps(store MapStore, now time.Time) (int, error) {
+	bathroomMaps, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+
+	swept := 0
+	for _, bathroomMap := range bathroomMaps {
+		if !bathroomMap.isExpired(now) {
+			continue
+		}
+		err := store.Delete(bathroomMap.ID, bathroomMap.Revision)
+		if errors.Is(err, errMapNotFound) || errors.Is(err, errRevisionConflict) {
+			continue
+		} else if err != nil {
+			return swept, err
+		}
+		removeThumbnail(bathroomMap.ID)
+		swept += 1
+	}
+	return swept, nil
+}
+
+// runJanitor sweeps expired maps out of the store every interval, forever
+func runJanitor(store MapStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		swept, err := sweepExpiredMaps(store, now)
+		if err != nil {
+			log.Println("Error Sweeping Expired Maps:", err)
+			continue
+		}
+		if swept > 0 {
+			log.Printf("Swept %d expired maps", swept)
+		}
+	}
+}
+
+// MapPin pins a map so it is never swept, or unpins it
+type MapPin struct {
+	ID     int  `json:"ID"`
+	Pinned bool `json:"pinned"`
+}
+
+// pin or unpin a map, admin only
+func mapPinHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var mapPin MapPin
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&mapPin); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// pinning is not an edit, so the map keeps its revision
+	var bathroomMap BathroomMapOutput
+	err := mapStore.Modify(mapPin.ID, func(stored *BathroomMapOutput) error {
+		stored.Pinned = mapPin.Pinned
+		bathroomMap = *stored
+		return nil
+	})
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(bathroomMap)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// MapRevision is a map as it was saved at one revision. Every create and
+// update of a map is kept, numbered like BathroomMapOutput.Revision.
+type MapRevision struct {
+	Revision int               `json:"revision"`
+	Author   string            `json:"author"`
+	Time     time.Time         `json:"time"`
+	Map      BathroomMapOutput `json:"map"`
+}
+
+// MapRevisionSummary lists a revision without the map it holds
+type MapRevisionSummary struct {
+	Revision int       `json:"revision"`
+	Author   string    `json:"author"`
+	Time     time.Time `json:"time"`
+}
+
+// snapshot a map as it is being saved
+func newMapRevision(bathroomMap BathroomMapOutput) MapRevision {
+	return MapRevision{
+		Revision: bathroomMap.Revision,
+		Author:   bathroomMap.Author,
+		Time:     time.Now(),
+		Map:      bathroomMap,
+	}
+}
+
+// CellChange is a grid cell which holds a different value in two revisions
+type CellChange struct {
+	Cell
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// BathroomChange is a bathroom whose details differ between two revisions
+type BathroomChange struct {
+	From Bathroom `json:"from"`
+	To   Bathroom `json:"to"`
+}
+
+// MapDiff lists what changed going from one revision of a map to another.
+// Resized is set when the floors or their dimensions differ, in which case
+// only the cells both revisions have are compared.
+type MapDiff struct {
+	From     int              `json:"from"`
+	To       int              `json:"to"`
+	Resized  bool             `json:"resized"`
+	Cells    []CellChange     `json:"cells"`
+	Added    []Bathroom       `json:"added"`
+	Removed  []Bathroom       `json:"removed"`
+	Modified []BathroomChange `json:"modified"`
+}
+
+// diffMaps compares every floor and the bathroom list of two maps
+func diffMaps(from, to BathroomMap) MapDiff {
+	diff := MapDiff{
+		Cells:    make([]CellChange, 0),
+		Added:    make([]Bathroom, 0),
+		Removed:  make([]Bathroom, 0),
+		Modified: make([]BathroomChange, 0),
+	}
+
+	fromFloors, toFloors := from.floorGrids(), to.floorGrids()
+	if len(fromFloors) != len(toFloors) {
+		diff.Resized = true
+	}
+	for floor := 0; floor < len(fromFloors) && floor < len(toFloors); floor++ {
+		fromGrid, toGrid := fromFloors[floor], toFloors[floor]
+		if len(fromGrid) != len(toGrid) {
+			diff.Resized = true
+		}
+		for x := 0; x < len(fromGrid) && x < len(toGrid); x++ {
+			if len(fromGrid[x]) != len(toGrid[x]) {
+				diff.Resized = true
+			}
+			for y := 0; y < len(fromGrid[x]) && y < len(toGrid[x]); y++ {
+				if fromGrid[x][y] != toGrid[x][y] {
+					diff.Cells = append(diff.Cells, CellChange{Cell{floor, x, y}, fromGrid[x][y], toGrid[x][y]})
+				}
+			}
+		}
+	}
+
+	// bathrooms are matched up by ID
+	for _, bathroom := range to.Bathrooms {
+		old := findBathroomByID(from.Bathrooms, bathroom.ID)
+		if old == nil {
+			diff.Added = append(diff.Added, bathroom)
+		} else if !reflect.DeepEqual(*old, bathroom) {
+			diff.Modified = append(diff.Modified, BathroomChange{*old, bathroom})
+		}
+	}
+	for _, bathroom := range from.Bathrooms {
+		if findBathroomByID(to.Bathrooms, bathroom.ID) == nil {
+			diff.Removed = append(diff.Removed, bathroom)
+		}
+	}
+	return diff
+}
+
+// MapRevisionRequest names a map, and one of its revisions where needed
+type MapRevisionRequest struct {
+	ID       int `json:"ID"`
+	Revision int `json:"revision"`
+}
+
+// MapDiffRequest names a map and the two revisions to compare
+type MapDiffRequest struct {
+	ID   int `json:"ID"`
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+// MapRollback restores the contents of revision To as a new revision of the
+// map. Revision is the revision the map was last seen at, as for
+// BathroomMapUpdate. The new revision is credited to the caller.
+type MapRollback struct {
+	ID       int `json:"ID"`
+	Revision int `json:"revision"`
+	To       int `json:"to"`
+}
+
+// write the error for a failed revision lookup
+func revisionError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+	} else if errors.Is(err, errRevisionNotFound) {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+	} else {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// list every saved revision of a map
+func mapRevisionsHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var revisionReq MapRevisionRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&revisionReq); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	// the history of a deleted map goes with it
+	if _, err := mapStore.Get(revisionReq.ID); err != nil {
+		revisionError(w, err)
+		return
+	}
+	revisions, err := mapStore.Revisions(revisionReq.ID)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+
+	summaries := make([]MapRevisionSummary, len(revisions))
+	for i, revision := range revisions {
+		summaries[i] = MapRevisionSummary{revision.Revision, revision.Author, revision.Time}
+	}
+
+	jsonResponse, err := json.Marshal(summaries)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// fetch a map as it was at one revision
+func mapRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var revisionReq MapRevisionRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&revisionReq); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	revision, err := mapStore.GetRevision(revisionReq.ID, revisionReq.Revision)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(revision)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// compare two revisions of a map
+func mapDiffHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var diffReq MapDiffRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&diffReq); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	from, err := mapStore.GetRevision(diffReq.ID, diffReq.From)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+	to, err := mapStore.GetRevision(diffReq.ID, diffReq.To)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+
+	diff := diffMaps(ConvertOutputToMap(from.Map), ConvertOutputToMap(to.Map))
+	diff.From, diff.To = from.Revision, to.Revision
+
+	jsonResponse, err := json.Marshal(diff)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// save an older revision of a map as its newest one
+func mapRollbackHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var rollback MapRollback
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&rollback); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	if rollback.Revision < 1 {
+		http.Error(w, "Invalid revision", http.StatusBadRequest)
+		return
+	}
+	current, err := mapStore.Get(rollback.ID)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+	target, err := mapStore.GetRevision(rollback.ID, rollback.To)
+	if err != nil {
+		revisionError(w, err)
+		return
+	}
+
+	// only the contents go back, the map keeps its identity and retention
+	restored := target.Map
+	restored.ID = current.ID
+	restored.Time = current.Time
+	restored.Revision = rollback.Revision
+	restored.Pinned = current.Pinned
+	restored.Retention = current.Retention
+	restored.Delete = current.Delete
+	restored.Statuses = current.Statuses
+	restored.Author = caller(r)
+
+	err = mapStore.Update(restored)
+	if errors.Is(err, errRevisionConflict) {
+		http.Error(w, "Map was changed since it was loaded", http.StatusConflict)
+		return
+	} else if err != nil {
+		revisionError(w, err)
+		return
+	}
+	restored.Revision += 1
+	refreshThumbnail(restored)
+
+	jsonResponse, err := json.Marshal(restored)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
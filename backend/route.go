@@ -0,0 +1,230 @@
+package main
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Cell is a grid position as seen by API clients. Row indexes the grid and Col
+// indexes the row, matching Point.x and Point.y. Floor is only used by
+// multi-floor maps.
+type Cell struct {
+	Floor int `json:"floor,omitempty"`
+	Row   int `json:"row"`
+	Col   int `json:"col"`
+}
+
+// RouteRequest asks for directions from Start to the nearest bathroom. Either
+// ID names a stored map or Matrix (or Floors and Connectors) carries a raw
+// grid, described by Bathrooms and placed on the earth by Coordinates. From
+// gives the start as a position instead, on the floor of Start.
+type RouteRequest struct {
+	ID          int             `json:"ID"`
+	Matrix      [][]int         `json:"matrix"`
+	Floors      [][][]int       `json:"floors"`
+	Connectors  []Connector     `json:"connectors"`
+	Bathrooms   []Bathroom      `json:"bathrooms"`
+	Coordinates []Coordinates   `json:"coordinates"`
+	Start       Cell            `json:"start"`
+	From        *Coordinates    `json:"from"`
+	Filter      *BathroomFilter `json:"filter"`
+	Costs       CellCosts       `json:"costs"`
+	// AccessibleOnly avoids stairs and steps and only targets accessible
+	// bathrooms
+	AccessibleOnly bool `json:"accessibleOnly"`
+	// At leaves out the bathrooms which are closed or out of service at that
+	// time, now when it is not set
+	At *time.Time `json:"at"`
+}
+
+// RouteResponse is the walking path from the start cell to the nearest
+// reachable bathroom. Length is the walking cost of the path and Steps the
+// number of cells moved. Bathroom is only set when the map has metadata for it
+// and Positions, the centers of the path cells, when it has coordinates.
+type RouteResponse struct {
+	BathroomID int           `json:"bathroomId"`
+	Bathroom   *Bathroom     `json:"bathroom,omitempty"`
+	Path       []Cell        `json:"path"`
+	Positions  []Coordinates `json:"positions,omitempty"`
+	Length     int           `json:"length"`
+	Steps      int           `json:"steps"`
+}
+
+func routeHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var routeReq RouteRequest
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&routeReq); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	bathroomMap, err := resolveMapRequest(routeReq.ID, BathroomMap{
+		Grid:        routeReq.Matrix,
+		Floors:      routeReq.Floors,
+		Connectors:  routeReq.Connectors,
+		Bathrooms:   routeReq.Bathrooms,
+		Coordinates: routeReq.Coordinates,
+	})
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	at := time.Now()
+	if routeReq.At != nil {
+		at = *routeReq.At
+	}
+	floors, connectors := bathroomMap.availableFloors(at), bathroomMap.Connectors
+	if routeReq.AccessibleOnly {
+		floors, connectors, routeReq.Filter = applyAccessibleOnly(floors, connectors, routeReq.Filter)
+	}
+	b, err := newBuilding(floors, connectors, routeReq.Costs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	transform, geoErr := bathroomMap.geoTransform()
+	if routeReq.From != nil {
+		if geoErr != nil {
+			http.Error(w, geoErr.Error(), http.StatusBadRequest)
+			return
+		}
+		point, ok := transform.cellAt(*routeReq.From)
+		if !ok {
+			http.Error(w, "From is outside the map", http.StatusBadRequest)
+			return
+		}
+		routeReq.Start.Row, routeReq.Start.Col = point.x, point.y
+	}
+	start := b.cellPoint(routeReq.Start)
+	if !b.walkable(start) {
+		http.Error(w, "Start must be a walkable cell inside the grid", http.StatusBadRequest)
+		return
+	}
+
+	path, length := b.nearestPath(start, b.findSites(bathroomMap.Bathrooms, routeReq.Filter))
+	if path == nil {
+		http.Error(w, "No reachable bathroom", http.StatusNotFound)
+		return
+	}
+
+	end := path[len(path)-1]
+	bathroomID := b.floors[end.floor][end.point.x][end.point.y]
+	routeResponse := RouteResponse{
+		BathroomID: bathroomID,
+		Bathroom:   findBathroomByID(bathroomMap.Bathrooms, bathroomID),
+		Path:       floorPointsToCells(path),
+		Length:     length,
+		Steps:      len(path) - 1,
+	}
+	if geoErr == nil {
+		routeResponse.Positions = transform.cellPositions(path)
+	}
+
+	jsonResponse, err := json.Marshal(routeResponse)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// nearestPath searches outward from start until it reaches one of the given
+// sites and returns the path to it along with its walking cost. When several
+// sites are equally close the one with the lowest ID wins. The path is nil and
+// the cost -1 when no site can be reached.
+func (b building) nearestPath(start floorPoint, sites []buildingSite) ([]floorPoint, int) {
+	siteIDs := make(map[floorPoint]int, len(sites))
+	for _, site := range sites {
+		siteIDs[site.at] = site.id
+	}
+
+	cameFrom := make(map[floorPoint]floorPoint)
+	distances := map[floorPoint]int{start: 0}
+	settled := make(map[floorPoint]bool)
+	queue := floodQueue{{start, 0, 0}}
+
+	bestDistance := -1
+	var best floorPoint
+	for len(queue) > 0 {
+		item := heap.Pop(&queue).(floodItem)
+		current := item.at
+		if settled[current] {
+			continue
+		}
+		// everything left in the queue is farther than the best site
+		if bestDistance != -1 && item.distance > bestDistance {
+			break
+		}
+		settled[current] = true
+
+		if id, ok := siteIDs[current]; ok {
+			if bestDistance == -1 || id < siteIDs[best] {
+				bestDistance = item.distance
+				best = current
+			}
+			continue
+		}
+
+		for _, move := range b.moves(current) {
+			nextDistance := item.distance + move.cost
+			if distance, seen := distances[move.to]; seen && distance <= nextDistance {
+				continue
+			}
+			distances[move.to] = nextDistance
+			cameFrom[move.to] = current
+			heap.Push(&queue, floodItem{move.to, nextDistance, 0})
+		}
+	}
+
+	if bestDistance == -1 {
+		return nil, -1
+	}
+
+	// walk back from the bathroom to the start
+	path := []floorPoint{best}
+	for current := best; current != start; {
+		current = cameFrom[current]
+		path = append(path, current)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, bestDistance
+}
+
+// find the bathroom metadata for a grid id, nil if the map has none
+func findBathroomByID(bathrooms []Bathroom, id int) *Bathroom {
+	for i := range bathrooms {
+		if bathrooms[i].ID == id {
+			return &bathrooms[i]
+		}
+	}
+	return nil
+}
+
+// convert building points into API cells
+func floorPointsToCells(points []floorPoint) []Cell {
+	cells := make([]Cell, len(points))
+	for i, fp := range points {
+		cells[i] = Cell{fp.floor, fp.point.x, fp.point.y}
+	}
+	return cells
+}
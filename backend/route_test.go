@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNearestPath(t *testing.T) {
+	tests := []struct {
+		name       string
+		matrix     [][]int
+		start      Point
+		wantPath   []Point
+		wantLength int
+	}{
+		{"already at a bathroom", [][]int{{1, 0}}, Point{0, 0}, []Point{{0, 0}}, 0},
+		{"straight walk", [][]int{{1, 0, 0}}, Point{0, 2}, []Point{{0, 2}, {0, 1}, {0, 0}}, 2},
+		{"equidistant tie goes to the lower ID", [][]int{{2, 0, 1}}, Point{0, 1}, []Point{{0, 1}, {0, 2}}, 1},
+		{"around a wall", [][]int{
+			{1, -1, 0},
+			{0, 0, 0},
+		}, Point{0, 2}, []Point{{0, 2}, {1, 2}, {1, 1}, {1, 0}, {0, 0}}, 4},
+		{"around costly stairs", [][]int{
+			{1, CellStairs, CellStairs, 0},
+			{0, 0, 0, 0},
+		}, Point{0, 3}, []Point{{0, 3}, {1, 3}, {1, 2}, {1, 1}, {1, 0}, {0, 0}}, 5},
+		{"unreachable", [][]int{{1, -1, 0}}, Point{0, 2}, nil, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := newBuilding([][][]int{test.matrix}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path, length := b.nearestPath(floorPoint{0, test.start}, b.findSites(nil, nil))
+			var got []Point
+			for _, fp := range path {
+				got = append(got, fp.point)
+			}
+			if !reflect.DeepEqual(got, test.wantPath) || length != test.wantLength {
+				t.Errorf("path %v of length %d, want %v of length %d", got, length, test.wantPath, test.wantLength)
+			}
+		})
+	}
+}
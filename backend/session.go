@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// how long a login lasts
+const sessionTTL = 7 * 24 * time.Hour
+
+// errInvalidSession is returned for tokens which are malformed, forged,
+// expired or revoked
+var errInvalidSession = errors.New("invalid session")
+
+// sessionSecret signs every session token, set up in main
+var sessionSecret []byte
+
+// loadSessionSecret reads the signing key from BATHROOM_SESSION_SECRET. Without
+// one a random key is made, so sessions end when the server restarts.
+func loadSessionSecret() ([]byte, error) {
+	if secret := os.Getenv("BATHROOM_SESSION_SECRET"); secret != "" {
+		return []byte(secret), nil
+	}
+	log.Println("BATHROOM_SESSION_SECRET is not set, sessions will not survive a restart")
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// Session is what a session token carries
+type Session struct {
+	ID        string `json:"sid"`
+	Username  string `json:"sub"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// start a new session for a user
+func newSession(username string) (Session, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Session{}, err
+	}
+	return Session{
+		ID:        hex.EncodeToString(id),
+		Username:  username,
+		ExpiresAt: time.Now().Add(sessionTTL).Unix(),
+	}, nil
+}
+
+func (session Session) expiresAt() time.Time {
+	return time.Unix(session.ExpiresAt, 0)
+}
+
+// sign a session into a token, the encoded session and its HMAC joined by a dot
+func signSession(session Session, secret []byte) (string, error) {
+	payload, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(sessionMAC(encoded, secret)), nil
+}
+
+func sessionMAC(encoded string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+// verifySession checks the signature and expiry of a token and that it was not
+// revoked by logging out
+func verifySession(token string, secret []byte, users UserStore) (Session, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return Session{}, errInvalidSession
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, sessionMAC(encoded, secret)) {
+		return Session{}, errInvalidSession
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Session{}, errInvalidSession
+	}
+	var session Session
+	if err := json.Unmarshal(payload, &session); err != nil {
+		return Session{}, errInvalidSession
+	}
+	if time.Now().After(session.expiresAt()) {
+		return Session{}, errInvalidSession
+	}
+
+	revoked, err := users.IsRevoked(session.ID)
+	if err != nil {
+		return Session{}, err
+	}
+	if revoked {
+		return Session{}, errInvalidSession
+	}
+	return session, nil
+}
+
+// key of the caller's session in the request context
+type sessionKey struct{}
+
+// callerSession returns the session of the logged in caller, if any
+func callerSession(r *http.Request) (Session, bool) {
+	session, ok := r.Context().Value(sessionKey{}).(Session)
+	return session, ok
+}
+
+// caller returns the username of the logged in caller, or "" for anonymous
+// requests
+func caller(r *http.Request) string {
+	session, _ := callerSession(r)
+	return session.Username
+}
+
+// withUser is a middleware function which attaches the caller to requests
+// carrying a session token. Requests without one go through anonymously, a
+// token which is not valid is rejected.
+func withUser(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			handler(w, r)
+			return
+		}
+
+		session, err := verifySession(token, sessionSecret, userStore)
+		if errors.Is(err, errInvalidSession) {
+			http.Error(w, "Invalid session", http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), sessionKey{}, session)))
+	}
+}
+
+// requireUser is a middleware function which only lets logged in callers
+// through, it goes inside withUser
+func requireUser(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := callerSession(r); !ok {
+			http.Error(w, "Login required", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Credentials are sent to register and to log in
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// UserInfo is the public view of an account
+type UserInfo struct {
+	Username string    `json:"username"`
+	Created  time.Time `json:"created"`
+}
+
+// LoginResponse holds the session token to send as a bearer token
+type LoginResponse struct {
+	Username  string    `json:"username"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// create a new account
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var credentials Credentials
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&credentials); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	user, err := newUser(credentials.Username, credentials.Password)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = userStore.CreateUser(user)
+	if errors.Is(err, errUserExists) {
+		http.Error(w, "Username is taken", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(UserInfo{user.Username, user.Created})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	w.Write(jsonResponse)
+}
+
+// check a username and password and start a session
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Decode JSON request
+	var credentials Credentials
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&credentials); err != nil {
+		http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+		return
+	}
+	defer r.Body.Close()
+
+	user, err := userStore.GetUser(normalizeUsername(credentials.Username))
+	if err != nil && !errors.Is(err, errUserNotFound) {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	// unknown users and wrong passwords look the same
+	if err != nil || !user.checkPassword(credentials.Password) {
+		http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := newSession(user.Username)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	token, err := signSession(session, sessionSecret)
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(LoginResponse{user.Username, token, session.expiresAt()})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
+
+// revoke the session the request was made with
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	// Only allow POST requests
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	session, _ := callerSession(r)
+	if err := userStore.RevokeSession(session.ID, session.expiresAt()); err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// the account of the logged in caller
+func currentUserHandler(w http.ResponseWriter, r *http.Request) {
+	//Allow only GET requests
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	user, err := userStore.GetUser(caller(r))
+	if errors.Is(err, errUserNotFound) {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	jsonResponse, err := json.Marshal(UserInfo{user.Username, user.Created})
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Bathroom statuses, every status but open takes the bathroom out of diagrams
+// and routes
+const (
+	StatusOpen       = "open"
+	StatusClosed     = "closed"
+	StatusOutOfOrder = "outOfOrder"
+	StatusCleaning   = "cleaning"
+)
+
+// BathroomStatus temporarily takes a bathroom of a stored map out of service,
+// until Until when it is set
+type BathroomStatus struct {
+	BathroomID int        `json:"bathroomId"`
+	Status     string     `json:"status"`
+	Until      *time.Time `json:"until,omitempty"`
+	Note       string     `json:"note,omitempty"`
+	SetBy      string     `json:"setBy,omitempty"`
+	SetAt      time.Time  `json:"setAt"`
+}
+
+func (status BathroomStatus) validate() error {
+	switch status.Status {
+	case StatusOpen, StatusClosed, StatusOutOfOrder, StatusCleaning:
+	default:
+		return fmt.Errorf("unknown status %q", status.Status)
+	}
+	if status.Until != nil && status.Until.Before(time.Now()) {
+		return errors.New("until must be in the future")
+	}
+	return nil
+}
+
+// check the status still holds at now
+func (status BathroomStatus) activeAt(now time.Time) bool {
+	return status.Status != StatusOpen && (status.Until == nil || now.Before(*status.Until))
+}
+
+// the statuses which still hold at now
+func activeStatuses(statuses []BathroomStatus, now time.Time) []BathroomStatus {
+	active := make([]BathroomStatus, 0, len(statuses))
+	for _, status := range statuses {
+		if status.activeAt(now) {
+			active = append(active, status)
+		}
+	}
+	return active
+}
+
+// unavailableAt lists the bathrooms of the map which are out of service or
+// outside their opening hours at now
+func (bathroomMap BathroomMap) unavailableAt(now time.Time) map[int]bool {
+	unavailable := make(map[int]bool)
+	for _, status := range activeStatuses(bathroomMap.Statuses, now) {
+		unavailable[status.BathroomID] = true
+	}
+	for _, bathroom := range bathroomMap.Bathrooms {
+		if bathroom.Hours != nil && !bathroom.Hours.openAt(now) {
+			unavailable[bathroom.ID] = true
+		}
+	}
+	return unavailable
+}
+
+// availableFloors is floorGrids with the bathrooms which are unavailable at now
+// turned into open floor, so they are still walked through but no longer
+// count as sites
+func (bathroomMap BathroomMap) availableFloors(now time.Time) [][][]int {
+	return bathroomMap.floorsWithout(bathroomMap.unavailableAt(now))
+}
+
+// floorGrids with the given bathrooms turned into open floor
+func (bathroomMap BathroomMap) floorsWithout(unavailable map[int]bool) [][][]int {
+	floors := bathroomMap.floorGrids()
+	if len(unavailable) == 0 {
+		return floors
+	}
+
+	available := make([][][]int, len(floors))
+	for floor, matrix := range floors {
+		available[floor] = make([][]int, len(matrix))
+		for x := range matrix {
+			available[floor][x] = make([]int, len(matrix[x]))
+			for y, cell := range matrix[x] {
+				if unavailable[cell] {
+					cell = 0
+				}
+				available[floor][x][y] = cell
+			}
+		}
+	}
+	return available
+}
+
+// errBathroomNotFound is returned when a status names a bathroom the map lacks
+var errBathroomNotFound = errors.New("bathroom not found")
+
+// StatusRequest sets the status of a bathroom, StatusOpen clears it
+type StatusRequest struct {
+	BathroomID int        `json:"bathroomId"`
+	Status     string     `json:"status"`
+	Until      *time.Time `json:"until"`
+	Note       string     `json:"note"`
+}
+
+// read or set bathroom statuses, GET or POST /api/maps/{id}/status. Setting a
+// status needs a logged in user.
+func mapStatusHandler(w http.ResponseWriter, r *http.Request, id int) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	bathroomMap, err := mapStore.Get(id)
+	if errors.Is(err, errMapNotFound) {
+		http.Error(w, "Map not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		// only logged in users may take bathrooms out of service
+		if _, ok := callerSession(r); !ok {
+			http.Error(w, "Login required", http.StatusUnauthorized)
+			return
+		}
+
+		// Decode JSON request
+		var statusReq StatusRequest
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&statusReq); err != nil {
+			http.Error(w, "Invalid JSON input", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		status := BathroomStatus{
+			BathroomID: statusReq.BathroomID,
+			Status:     statusReq.Status,
+			Until:      statusReq.Until,
+			Note:       statusReq.Note,
+			SetBy:      caller(r),
+			SetAt:      time.Now(),
+		}
+		if err := status.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// statuses are live data rather than an edit of the map, so they are
+		// saved without a new revision and never conflict with editors
+		err = mapStore.Modify(id, func(stored *BathroomMapOutput) error {
+			if findBathroomByID(stored.Bathrooms, status.BathroomID) == nil {
+				return errBathroomNotFound
+			}
+			// replace the old status of the bathroom and forget expired ones
+			statuses := make([]BathroomStatus, 0, len(stored.Statuses)+1)
+			for _, existing := range activeStatuses(stored.Statuses, status.SetAt) {
+				if existing.BathroomID != status.BathroomID {
+					statuses = append(statuses, existing)
+				}
+			}
+			if status.Status != StatusOpen {
+				statuses = append(statuses, status)
+			}
+			stored.Statuses = statuses
+			bathroomMap = *stored
+			return nil
+		})
+		if errors.Is(err, errBathroomNotFound) {
+			http.Error(w, "Bathroom not found", http.StatusNotFound)
+			return
+		} else if errors.Is(err, errMapNotFound) {
+			http.Error(w, "Map not found", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	jsonResponse, err := json.Marshal(activeStatuses(bathroomMap.Statuses, time.Now()))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonResponse)
+}
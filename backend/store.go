@@ -0,0 +1,599 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// store backends selectable through BATHROOM_STORE
+const (
+	StoreJSON = "json"
+	StoreBolt = "bolt"
+)
+
+// default location of the bolt database
+const boltDB = "bathrooms.db"
+
+// errMapExists is returned when creating a map whose ID is already taken
+var errMapExists = errors.New("BathroomMap already exists")
+
+// errRevisionConflict is returned when a map changed since the caller read it
+var errRevisionConflict = errors.New("BathroomMap revision conflict")
+
+// errRevisionNotFound is returned when a map has no saved revision by a number
+var errRevisionNotFound = errors.New("BathroomMap revision not found")
+
+// MapStore persists bathroom maps. Get, Update and Delete return
+// errMapNotFound for unknown IDs.
+type MapStore interface {
+	// List returns every stored map
+	List() ([]BathroomMapOutput, error)
+	Get(id int) (BathroomMapOutput, error)
+	// Create stores a new map, failing with errMapExists if its ID is taken
+	Create(bathroomMap BathroomMapOutput) error
+	// Update replaces the stored map with the same ID and bumps its revision.
+	// bathroomMap.Revision must be the revision the edit was based on, or the
+	// update fails with errRevisionConflict. Pins and statuses are only
+	// changed through Modify, so the stored ones are kept.
+	Update(bathroomMap BathroomMapOutput) error
+	// Delete removes the map together with its history if it is still at
+	// revision
+	Delete(id int, revision int) error
+	// Revisions returns every saved revision of a map, oldest first. Create
+	// and Update record a revision in the same write as the map itself.
+	Revisions(id int) ([]MapRevision, error)
+	// GetRevision returns one saved revision, failing with
+	// errRevisionNotFound if the map has no such revision
+	GetRevision(id int, revision int) (MapRevision, error)
+	// Modify applies change to the stored map in the same write that saves
+	// it, keeping its ID and revision and recording no revision. It is meant
+	// for state kept alongside a map, like pinning, which is not an edit of
+	// the map and must not invalidate the revision an editor holds.
+	Modify(id int, change func(bathroomMap *BathroomMapOutput) error) error
+	Close() error
+}
+
+// run change on a copy of stored, keeping the ID and revision out of its reach
+func applyModify(stored BathroomMapOutput, change func(bathroomMap *BathroomMapOutput) error) (BathroomMapOutput, error) {
+	modified := stored
+	if err := change(&modified); err != nil {
+		return BathroomMapOutput{}, err
+	}
+	modified.ID, modified.Revision = stored.ID, stored.Revision
+	return modified, nil
+}
+
+// check a stored map is at the revision the caller expects. Revisions start
+// at 1, so a revision sent from outside can never skip the check.
+func checkRevision(stored BathroomMapOutput, revision int) error {
+	if stored.Revision != revision {
+		return errRevisionConflict
+	}
+	return nil
+}
+
+// mapStore is the store every handler reads and writes, set up in main
+var mapStore MapStore
+
+// openMapStore opens the store backend named by kind at path, falling back to
+// the default file of the backend when path is empty
+func openMapStore(kind, path string) (MapStore, error) {
+	switch kind {
+	case "", StoreJSON:
+		if path == "" {
+			path = bathroomsDB
+		}
+		return &jsonMapStore{path: path}, nil
+	case StoreBolt:
+		if path == "" {
+			path = boltDB
+		}
+		return openBoltMapStore(path)
+	default:
+		return nil, fmt.Errorf("unknown store %q", kind)
+	}
+}
+
+// migrateMaps copies every map from one store into another, skipping the ones
+// already present, and returns how many were copied
+func migrateMaps(from, to MapStore) (int, error) {
+	bathroomMaps, err := from.List()
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, bathroomMap := range bathroomMaps {
+		err := to.Create(bathroomMap)
+		if errors.Is(err, errMapExists) {
+			continue
+		} else if err != nil {
+			return migrated, err
+		}
+		migrated += 1
+	}
+	return migrated, nil
+}
+
+// jsonMapStore keeps every map in a single JSON array on disk. Every operation
+// holds the lock for its whole read-modify-write and the file is replaced
+// atomically, so concurrent saves cannot lose maps and a crash cannot leave a
+// truncated file behind.
+type jsonMapStore struct {
+	path string
+	mu   sync.RWMutex
+}
+
+// read every map from the file, a missing file is an empty store
+func (store *jsonMapStore) load() ([]BathroomMapOutput, error) {
+	file, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var bathroomMaps []BathroomMapOutput
+	if err := json.Unmarshal(file, &bathroomMaps); err != nil {
+		return nil, err
+	}
+	return bathroomMaps, nil
+}
+
+// write every map back to the file
+func (store *jsonMapStore) save(bathroomMaps []BathroomMapOutput) error {
+	jsonData, err := json.MarshalIndent(bathroomMaps, "", " ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.path, jsonData, 0644)
+}
+
+func (store *jsonMapStore) List() ([]BathroomMapOutput, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	return store.load()
+}
+
+func (store *jsonMapStore) Get(id int) (BathroomMapOutput, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	bathroomMaps, err := store.load()
+	if err != nil {
+		return BathroomMapOutput{}, err
+	}
+	for _, bathroomMap := range bathroomMaps {
+		if bathroomMap.ID == id {
+			return bathroomMap, nil
+		}
+	}
+	return BathroomMapOutput{}, errMapNotFound
+}
+
+func (store *jsonMapStore) Create(bathroomMap BathroomMapOutput) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	bathroomMaps, err := store.load()
+	if err != nil {
+		return err
+	}
+	for _, existing := range bathroomMaps {
+		if existing.ID == bathroomMap.ID {
+			return errMapExists
+		}
+	}
+	if err := store.addRevision(bathroomMap); err != nil {
+		return err
+	}
+	return store.save(append(bathroomMaps, bathroomMap))
+}
+
+func (store *jsonMapStore) Update(bathroomMap BathroomMapOutput) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	bathroomMaps, err := store.load()
+	if err != nil {
+		return err
+	}
+	for i, existing := range bathroomMaps {
+		if existing.ID == bathroomMap.ID {
+			if err := checkRevision(existing, bathroomMap.Revision); err != nil {
+				return err
+			}
+			bathroomMap.Pinned, bathroomMap.Statuses = existing.Pinned, existing.Statuses
+			bathroomMap.Revision += 1
+			if err := store.addRevision(bathroomMap); err != nil {
+				return err
+			}
+			bathroomMaps[i] = bathroomMap
+			return store.save(bathroomMaps)
+		}
+	}
+	return errMapNotFound
+}
+
+func (store *jsonMapStore) Modify(id int, change func(bathroomMap *BathroomMapOutput) error) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	bathroomMaps, err := store.load()
+	if err != nil {
+		return err
+	}
+	for i, existing := range bathroomMaps {
+		if existing.ID == id {
+			modified, err := applyModify(existing, change)
+			if err != nil {
+				return err
+			}
+			bathroomMaps[i] = modified
+			return store.save(bathroomMaps)
+		}
+	}
+	return errMapNotFound
+}
+
+func (store *jsonMapStore) Delete(id int, revision int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	bathroomMaps, err := store.load()
+	if err != nil {
+		return err
+	}
+	for i, existing := range bathroomMaps {
+		if existing.ID == id {
+			if err := checkRevision(existing, revision); err != nil {
+				return err
+			}
+			if err := store.save(append(bathroomMaps[:i], bathroomMaps[i+1:]...)); err != nil {
+				return err
+			}
+			return store.dropRevisions(id)
+		}
+	}
+	return errMapNotFound
+}
+
+// the history lives next to the maps, bathroomsDB.json keeps its revisions in
+// bathroomsDB.revisions.json
+func (store *jsonMapStore) revisionsPath() string {
+	return strings.TrimSuffix(store.path, filepath.Ext(store.path)) + ".revisions.json"
+}
+
+// read the history of every map, a missing file is an empty history
+func (store *jsonMapStore) loadRevisions() (map[int][]MapRevision, error) {
+	file, err := os.ReadFile(store.revisionsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return make(map[int][]MapRevision), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	history := make(map[int][]MapRevision)
+	if err := json.Unmarshal(file, &history); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// write the history of every map back to its file
+func (store *jsonMapStore) saveRevisions(history map[int][]MapRevision) error {
+	jsonData, err := json.MarshalIndent(history, "", " ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.revisionsPath(), jsonData, 0644)
+}
+
+// record a saved map in its history. The history is written before the map,
+// so a crash in between leaves a revision the map never reached, which the
+// next save of that revision replaces.
+func (store *jsonMapStore) addRevision(bathroomMap BathroomMapOutput) error {
+	history, err := store.loadRevisions()
+	if err != nil {
+		return err
+	}
+	revisions := history[bathroomMap.ID]
+	for i, revision := range revisions {
+		if revision.Revision >= bathroomMap.Revision {
+			revisions = revisions[:i]
+			break
+		}
+	}
+	history[bathroomMap.ID] = append(revisions, newMapRevision(bathroomMap))
+	return store.saveRevisions(history)
+}
+
+// forget the history of a deleted map
+func (store *jsonMapStore) dropRevisions(id int) error {
+	history, err := store.loadRevisions()
+	if err != nil {
+		return err
+	}
+	if _, ok := history[id]; !ok {
+		return nil
+	}
+	delete(history, id)
+	return store.saveRevisions(history)
+}
+
+func (store *jsonMapStore) Revisions(id int) ([]MapRevision, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	history, err := store.loadRevisions()
+	if err != nil {
+		return nil, err
+	}
+	return history[id], nil
+}
+
+func (store *jsonMapStore) GetRevision(id int, revision int) (MapRevision, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	history, err := store.loadRevisions()
+	if err != nil {
+		return MapRevision{}, err
+	}
+	for _, mapRevision := range history[id] {
+		if mapRevision.Revision == revision {
+			return mapRevision, nil
+		}
+	}
+	return MapRevision{}, errRevisionNotFound
+}
+
+func (store *jsonMapStore) Close() error {
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers see either the old or the new contents in full
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	// clean up the temporary file unless it was renamed
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// sync the directory so the rename itself survives a crash
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// bucket holding one JSON encoded map per ID
+var mapsBucket = []byte("maps")
+
+// bucket holding one bucket per map ID, with one JSON encoded MapRevision per
+// revision number
+var revisionsBucket = []byte("revisions")
+
+// boltMapStore keeps maps in an embedded bolt database, which serializes
+// writers and never leaves a half written map behind
+type boltMapStore struct {
+	db *bolt.DB
+}
+
+func openBoltMapStore(path string) (*boltMapStore, error) {
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(mapsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(revisionsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltMapStore{db}, nil
+}
+
+// big endian keys keep the maps ordered by ID, and revisions by number
+func mapKey(id int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+// record a saved map in its history inside the transaction that saves it
+func putBoltRevision(tx *bolt.Tx, bathroomMap BathroomMapOutput) error {
+	history, err := tx.Bucket(revisionsBucket).CreateBucketIfNotExists(mapKey(bathroomMap.ID))
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(newMapRevision(bathroomMap))
+	if err != nil {
+		return err
+	}
+	return history.Put(mapKey(bathroomMap.Revision), value)
+}
+
+func (store *boltMapStore) List() ([]BathroomMapOutput, error) {
+	var bathroomMaps []BathroomMapOutput
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(mapsBucket).ForEach(func(_, value []byte) error {
+			var bathroomMap BathroomMapOutput
+			if err := json.Unmarshal(value, &bathroomMap); err != nil {
+				return err
+			}
+			bathroomMaps = append(bathroomMaps, bathroomMap)
+			return nil
+		})
+	})
+	return bathroomMaps, err
+}
+
+func (store *boltMapStore) Get(id int) (BathroomMapOutput, error) {
+	var bathroomMap BathroomMapOutput
+	err := store.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(mapsBucket).Get(mapKey(id))
+		if value == nil {
+			return errMapNotFound
+		}
+		return json.Unmarshal(value, &bathroomMap)
+	})
+	return bathroomMap, err
+}
+
+func (store *boltMapStore) Create(bathroomMap BathroomMapOutput) error {
+	value, err := json.Marshal(bathroomMap)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(mapsBucket)
+		if bucket.Get(mapKey(bathroomMap.ID)) != nil {
+			return errMapExists
+		}
+		if err := putBoltRevision(tx, bathroomMap); err != nil {
+			return err
+		}
+		return bucket.Put(mapKey(bathroomMap.ID), value)
+	})
+}
+
+// read a map inside a transaction
+func getBoltMap(bucket *bolt.Bucket, id int) (BathroomMapOutput, error) {
+	value := bucket.Get(mapKey(id))
+	if value == nil {
+		return BathroomMapOutput{}, errMapNotFound
+	}
+	var stored BathroomMapOutput
+	if err := json.Unmarshal(value, &stored); err != nil {
+		return BathroomMapOutput{}, err
+	}
+	return stored, nil
+}
+
+func (store *boltMapStore) Update(bathroomMap BathroomMapOutput) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(mapsBucket)
+		stored, err := getBoltMap(bucket, bathroomMap.ID)
+		if err != nil {
+			return err
+		}
+		if err := checkRevision(stored, bathroomMap.Revision); err != nil {
+			return err
+		}
+		bathroomMap.Pinned, bathroomMap.Statuses = stored.Pinned, stored.Statuses
+		bathroomMap.Revision += 1
+		value, err := json.Marshal(bathroomMap)
+		if err != nil {
+			return err
+		}
+		if err := putBoltRevision(tx, bathroomMap); err != nil {
+			return err
+		}
+		return bucket.Put(mapKey(bathroomMap.ID), value)
+	})
+}
+
+func (store *boltMapStore) Modify(id int, change func(bathroomMap *BathroomMapOutput) error) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(mapsBucket)
+		stored, err := getBoltMap(bucket, id)
+		if err != nil {
+			return err
+		}
+		modified, err := applyModify(stored, change)
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(modified)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(mapKey(id), value)
+	})
+}
+
+func (store *boltMapStore) Delete(id int, revision int) error {
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(mapsBucket)
+		stored, err := getBoltMap(bucket, id)
+		if err != nil {
+			return err
+		}
+		if err := checkRevision(stored, revision); err != nil {
+			return err
+		}
+		err = tx.Bucket(revisionsBucket).DeleteBucket(mapKey(id))
+		if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+			return err
+		}
+		return bucket.Delete(mapKey(id))
+	})
+}
+
+func (store *boltMapStore) Revisions(id int) ([]MapRevision, error) {
+	var revisions []MapRevision
+	err := store.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(revisionsBucket).Bucket(mapKey(id))
+		if history == nil {
+			return nil
+		}
+		return history.ForEach(func(_, value []byte) error {
+			var revision MapRevision
+			if err := json.Unmarshal(value, &revision); err != nil {
+				return err
+			}
+			revisions = append(revisions, revision)
+			return nil
+		})
+	})
+	return revisions, err
+}
+
+func (store *boltMapStore) GetRevision(id int, revision int) (MapRevision, error) {
+	var mapRevision MapRevision
+	err := store.db.View(func(tx *bolt.Tx) error {
+		history := tx.Bucket(revisionsBucket).Bucket(mapKey(id))
+		if history == nil {
+			return errRevisionNotFound
+		}
+		value := history.Get(mapKey(revision))
+		if value == nil {
+			return errRevisionNotFound
+		}
+		return json.Unmarshal(value, &mapRevision)
+	})
+	return mapRevision, err
+}
+
+func (store *boltMapStore) Close() error {
+	return store.db.Close()
+}
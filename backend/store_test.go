@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+// a fresh store of every backend
+func testStores(t *testing.T) map[string]MapStore {
+	dir := t.TempDir()
+	boltStore, err := openBoltMapStore(filepath.Join(dir, "bathrooms.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { boltStore.Close() })
+	return map[string]MapStore{
+		StoreJSON: &jsonMapStore{path: filepath.Join(dir, "bathroomsDB.json")},
+		StoreBolt: boltStore,
+	}
+}
+
+func TestMapStoreRevisions(t *testing.T) {
+	edit := func(revision int, name string) BathroomMapOutput {
+		return BathroomMapOutput{ID: 1, Name: name, Revision: revision}
+	}
+	errRejected := errors.New("rejected")
+
+	steps := []struct {
+		name    string
+		run     func(store MapStore) error
+		wantErr error
+		// revision of the stored map afterwards and how many were recorded
+		wantRevision  int
+		wantRevisions int
+		wantPinned    bool
+	}{
+		{"create", func(store MapStore) error {
+			return store.Create(edit(1, "created"))
+		}, nil, 1, 1, false},
+		{"create a taken ID", func(store MapStore) error {
+			return store.Create(edit(1, "again"))
+		}, errMapExists, 1, 1, false},
+		{"update bumps the revision", func(store MapStore) error {
+			return store.Update(edit(1, "updated"))
+		}, nil, 2, 2, false},
+		{"update from a stale revision", func(store MapStore) error {
+			return store.Update(edit(1, "stale"))
+		}, errRevisionConflict, 2, 2, false},
+		{"update from a negative revision", func(store MapStore) error {
+			return store.Update(edit(-1, "wildcard"))
+		}, errRevisionConflict, 2, 2, false},
+		{"update an unknown map", func(store MapStore) error {
+			return store.Update(BathroomMapOutput{ID: 2, Revision: 1})
+		}, errMapNotFound, 2, 2, false},
+		{"modify keeps the revision", func(store MapStore) error {
+			return store.Modify(1, func(bathroomMap *BathroomMapOutput) error {
+				bathroomMap.Pinned = true
+				bathroomMap.Statuses = []BathroomStatus{{BathroomID: 1, Status: "cleaning"}}
+				bathroomMap.ID, bathroomMap.Revision = 2, 7
+				return nil
+			})
+		}, nil, 2, 2, true},
+		{"failed modify changes nothing", func(store MapStore) error {
+			return store.Modify(1, func(bathroomMap *BathroomMapOutput) error {
+				bathroomMap.Pinned = false
+				return errRejected
+			})
+		}, errRejected, 2, 2, true},
+		{"modify an unknown map", func(store MapStore) error {
+			return store.Modify(2, func(bathroomMap *BathroomMapOutput) error { return nil })
+		}, errMapNotFound, 2, 2, true},
+		{"update keeps pins and statuses", func(store MapStore) error {
+			return store.Update(edit(2, "edited"))
+		}, nil, 3, 3, true},
+		{"delete from a stale revision", func(store MapStore) error {
+			return store.Delete(1, 2)
+		}, errRevisionConflict, 3, 3, true},
+		{"delete from a negative revision", func(store MapStore) error {
+			return store.Delete(1, -1)
+		}, errRevisionConflict, 3, 3, true},
+	}
+
+	for kind, store := range testStores(t) {
+		t.Run(kind, func(t *testing.T) {
+			for _, step := range steps {
+				if err := step.run(store); !errors.Is(err, step.wantErr) {
+					t.Fatalf("%s: err = %v, want %v", step.name, err, step.wantErr)
+				}
+				stored, err := store.Get(1)
+				if err != nil {
+					t.Fatalf("%s: %v", step.name, err)
+				}
+				if stored.Revision != step.wantRevision || stored.Pinned != step.wantPinned {
+					t.Errorf("%s: revision %d, pinned %v, want revision %d, pinned %v", step.name, stored.Revision, stored.Pinned, step.wantRevision, step.wantPinned)
+				}
+				if step.wantPinned && len(stored.Statuses) != 1 {
+					t.Errorf("%s: %d statuses, want 1", step.name, len(stored.Statuses))
+				}
+				revisions, err := store.Revisions(1)
+				if err != nil {
+					t.Fatalf("%s: %v", step.name, err)
+				}
+				if len(revisions) != step.wantRevisions {
+					t.Errorf("%s: %d revisions, want %d", step.name, len(revisions), step.wantRevisions)
+				}
+			}
+
+			// deleting drops the history too
+			if err := store.Delete(1, 3); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := store.Get(1); !errors.Is(err, errMapNotFound) {
+				t.Errorf("get after delete: err = %v, want %v", err, errMapNotFound)
+			}
+			if _, err := store.GetRevision(1, 1); !errors.Is(err, errRevisionNotFound) {
+				t.Errorf("revision after delete: err = %v, want %v", err, errRevisionNotFound)
+			}
+			if err := store.Delete(1, 3); !errors.Is(err, errMapNotFound) {
+				t.Errorf("second delete: err = %v, want %v", err, errMapNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/png"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// directory served under /images/
+const imagesDir = "./images/"
+
+// thumbnails live in this directory of imagesDir, one PNG per map ID
+const thumbnailsDir = "thumbnails"
+
+// longest side of a thumbnail in pixels, grids with more cells than this on a
+// side get one pixel per cell
+const thumbnailSize = 128
+
+func thumbnailPath(id int) string {
+	return filepath.Join(imagesDir, thumbnailsDir, fmt.Sprintf("%d.png", id))
+}
+
+// thumbnailURL is where the thumbnail of a map is served. The revision busts
+// browser caches when the map is updated.
+func thumbnailURL(id int, revision int) string {
+	return fmt.Sprintf("/images/%s/%d.png?v=%d", thumbnailsDir, id, revision)
+}
+
+// saveThumbnail renders the first floor of a map with every bathroom open, so
+// the thumbnail only goes stale when the map itself changes
+func saveThumbnail(bathroomMap BathroomMapOutput) error {
+	d, err := newDiagram(ConvertOutputToMap(bathroomMap), 0, nil)
+	if err != nil {
+		return err
+	}
+	side := d.rows()
+	if d.cols() > side {
+		side = d.cols()
+	}
+	if side == 0 {
+		return errors.New("cannot draw an empty grid")
+	}
+	cellSize := thumbnailSize / side
+	if cellSize < 1 {
+		cellSize = 1
+	}
+
+	if err := os.MkdirAll(filepath.Join(imagesDir, thumbnailsDir), 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, d.renderPNG(cellSize, false)); err != nil {
+		return err
+	}
+	return writeFileAtomic(thumbnailPath(bathroomMap.ID), buf.Bytes(), 0644)
+}
+
+// hasThumbnail checks if a thumbnail was rendered for a map. Thumbnails are
+// only rendered when a map is saved, never when the gallery lists it.
+func hasThumbnail(id int) bool {
+	_, err := os.Stat(thumbnailPath(id))
+	return err == nil
+}
+
+// refresh the thumbnail after a save, a failed render does not fail the save
+func refreshThumbnail(bathroomMap BathroomMapOutput) {
+	if err := saveThumbnail(bathroomMap); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// removeThumbnail deletes the thumbnail of a deleted map
+func removeThumbnail(id int) {
+	if err := os.Remove(thumbnailPath(id)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fmt.Println("Error:", err)
+	}
+}
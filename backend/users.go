@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// where the JSON store keeps accounts and revoked sessions
+const usersDB = "usersDB.json"
+
+// shortest password accepted on registration
+const minPasswordLength = 8
+
+// usernames are 3 to 32 letters, digits, dots, dashes or underscores
+var usernamePattern = regexp.MustCompile(`^[a-z0-9._-]{3,32}$`)
+
+// errUserExists is returned when registering a username which is taken
+var errUserExists = errors.New("user already exists")
+
+// errUserNotFound is returned for unknown usernames
+var errUserNotFound = errors.New("user not found")
+
+// User is a registered account. Only the bcrypt hash of the password is kept.
+type User struct {
+	Username     string    `json:"username"`
+	PasswordHash []byte    `json:"passwordHash"`
+	Created      time.Time `json:"created"`
+}
+
+// newUser checks the credentials of a registration and hashes the password
+func newUser(username, password string) (User, error) {
+	username = normalizeUsername(username)
+	if !usernamePattern.MatchString(username) {
+		return User{}, errors.New("username must be 3 to 32 letters, digits, dots, dashes or underscores")
+	}
+	if len(password) < minPasswordLength {
+		return User{}, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	// bcrypt only looks at the first 72 bytes
+	if len(password) > 72 {
+		return User{}, errors.New("password must be at most 72 bytes")
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+	return User{Username: username, PasswordHash: hash, Created: time.Now()}, nil
+}
+
+// usernames are case insensitive
+func normalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// check a password against the stored hash
+func (user User) checkPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(password)) == nil
+}
+
+// UserStore persists accounts and the sessions revoked by logging out.
+// GetUser returns errUserNotFound for unknown usernames.
+type UserStore interface {
+	// CreateUser stores a new account, failing with errUserExists if the
+	// username is taken
+	CreateUser(user User) error
+	GetUser(username string) (User, error)
+	// RevokeSession rejects the session from now on. It only has to be
+	// remembered until the session would have expired anyway.
+	RevokeSession(id string, expiresAt time.Time) error
+	IsRevoked(id string) (bool, error)
+}
+
+// userStore holds the accounts of every caller, set up in main
+var userStore UserStore
+
+// openUserStore opens the account store matching the map store, bolt keeps
+// accounts in the same database as the maps
+func openUserStore(maps MapStore) (UserStore, error) {
+	switch store := maps.(type) {
+	case *boltMapStore:
+		return openBoltUserStore(store.db)
+	default:
+		return &jsonUserStore{path: usersDB}, nil
+	}
+}
+
+// contents of the JSON user file
+type userFile struct {
+	Users []User `json:"users"`
+	// revoked session IDs and when they would have expired
+	Revoked map[string]time.Time `json:"revoked"`
+}
+
+// jsonUserStore keeps every account in a single JSON file, locked and written
+// atomically like jsonMapStore
+type jsonUserStore struct {
+	path string
+	mu   sync.RWMutex
+}
+
+// read the file, a missing file is an empty store
+func (store *jsonUserStore) load() (userFile, error) {
+	contents := userFile{Revoked: make(map[string]time.Time)}
+	file, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return contents, nil
+	} else if err != nil {
+		return contents, err
+	}
+	if err := json.Unmarshal(file, &contents); err != nil {
+		return contents, err
+	}
+	if contents.Revoked == nil {
+		contents.Revoked = make(map[string]time.Time)
+	}
+	return contents, nil
+}
+
+// write the file back, forgetting revocations of sessions which expired
+func (store *jsonUserStore) save(contents userFile) error {
+	now := time.Now()
+	for id, expiresAt := range contents.Revoked {
+		if now.After(expiresAt) {
+			delete(contents.Revoked, id)
+		}
+	}
+	jsonData, err := json.MarshalIndent(contents, "", " ")
+	if err != nil {
+		return err
+	}
+	// the file holds password hashes, keep it private
+	return writeFileAtomic(store.path, jsonData, 0600)
+}
+
+func (store *jsonUserStore) CreateUser(user User) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	contents, err := store.load()
+	if err != nil {
+		return err
+	}
+	for _, existing := range contents.Users {
+		if existing.Username == user.Username {
+			return errUserExists
+		}
+	}
+	contents.Users = append(contents.Users, user)
+	return store.save(contents)
+}
+
+func (store *jsonUserStore) GetUser(username string) (User, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	contents, err := store.load()
+	if err != nil {
+		return User{}, err
+	}
+	for _, user := range contents.Users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return User{}, errUserNotFound
+}
+
+func (store *jsonUserStore) RevokeSession(id string, expiresAt time.Time) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	contents, err := store.load()
+	if err != nil {
+		return err
+	}
+	contents.Revoked[id] = expiresAt
+	return store.save(contents)
+}
+
+func (store *jsonUserStore) IsRevoked(id string) (bool, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+	contents, err := store.load()
+	if err != nil {
+		return false, err
+	}
+	_, revoked := contents.Revoked[id]
+	return revoked, nil
+}
+
+// buckets holding one JSON encoded User per username, and the expiry of every
+// revoked session by ID
+var (
+	usersBucket   = []byte("users")
+	revokedBucket = []byte("revokedSessions")
+)
+
+// boltUserStore keeps accounts in the bolt database of the map store
+type boltUserStore struct {
+	db *bolt.DB
+}
+
+func openBoltUserStore(db *bolt.DB) (*boltUserStore, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(usersBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(revokedBucket)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &boltUserStore{db}, nil
+}
+
+func (store *boltUserStore) CreateUser(user User) error {
+	value, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		if bucket.Get([]byte(user.Username)) != nil {
+			return errUserExists
+		}
+		return bucket.Put([]byte(user.Username), value)
+	})
+}
+
+func (store *boltUserStore) GetUser(username string) (User, error) {
+	var user User
+	err := store.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(usersBucket).Get([]byte(username))
+		if value == nil {
+			return errUserNotFound
+		}
+		return json.Unmarshal(value, &user)
+	})
+	return user, err
+}
+
+func (store *boltUserStore) RevokeSession(id string, expiresAt time.Time) error {
+	value, err := json.Marshal(expiresAt)
+	if err != nil {
+		return err
+	}
+	return store.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(revokedBucket)
+
+		// forget revocations of sessions which expired
+		now := time.Now()
+		var expired [][]byte
+		err := bucket.ForEach(func(key, value []byte) error {
+			var revokedUntil time.Time
+			if err := json.Unmarshal(value, &revokedUntil); err != nil {
+				return err
+			}
+			if now.After(revokedUntil) {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		return bucket.Put([]byte(id), value)
+	})
+}
+
+func (store *boltUserStore) IsRevoked(id string) (bool, error) {
+	revoked := false
+	err := store.db.View(func(tx *bolt.Tx) error {
+		revoked = tx.Bucket(revokedBucket).Get([]byte(id)) != nil
+		return nil
+	})
+	return revoked, err
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt" 
 	"container/heap"
 	"sync"
@@ -81,7 +82,7 @@ func astar(matrix [][]int, start, end Point) ([]Point, int) {
 				continue
 			}
 
-			tentG := gScore[current] + 1
+			tentG := gScore[current] + defaultCellCosts.cost(matrix[neighbor.x][neighbor.y])
 
 			if _, ok := gScore[neighbor]; !ok || tentG < gScore[neighbor] {
 				gScore[neighbor] = tentG
@@ -366,6 +367,10 @@ func calculateNearestVoronoiID(matrix, outputMatrix [][]int, voronoiPoints []Poi
 
 
 func Voronoi(matrix [][]int, voronoiPointsWithIds []VoronoiPoint) [][]int {
+	// sampling needs at least one bathroom to sample around
+	if len(voronoiPointsWithIds) == 0 {
+		return VoronoiExact(matrix, voronoiPointsWithIds, nil)
+	}
 
 	// get voronoi points
 	voronoiPoints := make([]Point, len(voronoiPointsWithIds))
@@ -465,6 +470,19 @@ func Voronoi(matrix [][]int, voronoiPointsWithIds []VoronoiPoint) [][]int {
 	return outputMatrix
 }
 
+// make sure the matrix is non-empty and rectangular before walking it
+func validateMatrix(matrix [][]int) error {
+	if len(matrix) == 0 || len(matrix[0]) == 0 {
+		return errors.New("matrix is empty")
+	}
+	for _, row := range matrix {
+		if len(row) != len(matrix[0]) {
+			return errors.New("matrix rows have different lengths")
+		}
+	}
+	return nil
+}
+
 func FindBathrooms(matrix [][]int) ([]VoronoiPoint, []Point) {
 	// get size x
 	sizeX := len(matrix)
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Weightings selectable through VoronoiRequest.Weighting
+const (
+	// WeightingMultiplicative divides the walking distance to a bathroom by
+	// its weight
+	WeightingMultiplicative = "multiplicative"
+	// WeightingAdditive subtracts the weight of a bathroom from the walking
+	// distance to it
+	WeightingAdditive = "additive"
+)
+
+// walking cost one star of rating above or below the middle of the scale is
+// worth in additive diagrams weighted by ratings
+const additiveStarValue = 5
+
+// middle of the rating scale, which unrated bathrooms are treated as
+const neutralScore = float64(minScore+maxScore) / 2
+
+// the weight which leaves a bathroom's distances unchanged
+func neutralWeight(weighting string) float64 {
+	if weighting == WeightingMultiplicative {
+		return 1
+	}
+	return 0
+}
+
+// the distance a cell at walking distance d sees to a bathroom of weight
+func effectiveDistance(weighting string, distance int, weight float64) float64 {
+	if weighting == WeightingMultiplicative {
+		return float64(distance) / weight
+	}
+	return float64(distance) - weight
+}
+
+// checkWeights makes sure the weighting is known and every weight works
+// with it
+func checkWeights(weighting string, weights map[int]float64) error {
+	switch weighting {
+	case WeightingMultiplicative:
+		for id, weight := range weights {
+			if weight <= 0 {
+				return fmt.Errorf("weight of bathroom %d must be positive", id)
+			}
+		}
+	case WeightingAdditive:
+	default:
+		return fmt.Errorf("unknown weighting %q", weighting)
+	}
+	return nil
+}
+
+// ratingWeights turns the overall score of every bathroom in a stored map into
+// a weight. The middle of the scale is neutral, so unrated bathrooms neither
+// win nor lose cells.
+func ratingWeights(weighting string, mapID int, sites []buildingSite) (map[int]float64, error) {
+	weights := make(map[int]float64)
+	for _, site := range sites {
+		if _, ok := weights[site.id]; ok {
+			continue
+		}
+		ratings, err := ratingStore.Ratings(mapID, site.id)
+		if err != nil {
+			return nil, err
+		}
+		overall := summarizeRatings(mapID, site.id, ratings).Categories[RatingOverall]
+		score := neutralScore
+		if overall.Count > 0 {
+			score = overall.Mean
+		}
+		if weighting == WeightingMultiplicative {
+			weights[site.id] = score / neutralScore
+		} else {
+			weights[site.id] = (score - neutralScore) * additiveStarValue
+		}
+	}
+	return weights, nil
+}
+
+// weightedFlood is flood for a weighted diagram. Each bathroom is flooded on
+// its own, since a heavy bathroom can win cells beyond a light one, and every
+// cell goes to the bathroom with the smallest weighted distance, ties going to
+// the lower ID. Labels follow flood, the distances are the walking cost to
+// the owning bathroom.
+func (b building) weightedFlood(sites []buildingSite, weighting string, weights map[int]float64) ([][][]int, [][][]int) {
+	// a bathroom drawn over several cells is flooded from all of them
+	sitesByID := make(map[int][]buildingSite)
+	var ids []int
+	for _, site := range sites {
+		if _, ok := sitesByID[site.id]; !ok {
+			ids = append(ids, site.id)
+		}
+		sitesByID[site.id] = append(sitesByID[site.id], site)
+	}
+	sort.Ints(ids)
+
+	labels, distances := b.flood(nil)
+	best := make([][][]float64, len(b.floors))
+	for floor, matrix := range b.floors {
+		best[floor] = make([][]float64, len(matrix))
+		for x := range matrix {
+			best[floor][x] = make([]float64, len(matrix[x]))
+		}
+	}
+
+	for _, id := range ids {
+		weight, ok := weights[id]
+		if !ok {
+			weight = neutralWeight(weighting)
+		}
+		_, siteDistances := b.flood(sitesByID[id])
+		for floor := range siteDistances {
+			for x := range siteDistances[floor] {
+				for y, distance := range siteDistances[floor][x] {
+					if distance == Unreachable {
+						continue
+					}
+					// ids come in increasing order so only a strictly
+					// better bathroom takes the cell over
+					effective := effectiveDistance(weighting, distance, weight)
+					if labels[floor][x][y] == 0 || effective < best[floor][x][y] {
+						labels[floor][x][y] = id
+						distances[floor][x][y] = distance
+						best[floor][x][y] = effective
+					}
+				}
+			}
+		}
+	}
+	return labels, distances
+}
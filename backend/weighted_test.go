@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWeightedFlood(t *testing.T) {
+	tests := []struct {
+		name          string
+		matrix        [][]int
+		weighting     string
+		weights       map[int]float64
+		wantLabels    [][]int
+		wantDistances [][]int
+	}{
+		{"unweighted bathrooms match the plain flood", [][]int{{2, 0, 1}}, WeightingMultiplicative, nil,
+			[][]int{{2, 1, 1}},
+			[][]int{{0, 1, 0}}},
+		{"multiplicative", [][]int{{1, 0, 0, 0, 0, 0, 2}}, WeightingMultiplicative, map[int]float64{2: 2},
+			[][]int{{1, 1, 1, 2, 2, 2, 2}},
+			[][]int{{0, 1, 2, 3, 2, 1, 0}}},
+		{"additive", [][]int{{1, 0, 0, 0, 0, 0, 2}}, WeightingAdditive, map[int]float64{2: 3},
+			[][]int{{1, 1, 2, 2, 2, 2, 2}},
+			[][]int{{0, 1, 4, 3, 2, 1, 0}}},
+		{"heavy bathroom wins cells beyond a light one", [][]int{{2, 0, 1, 0, 0}}, WeightingMultiplicative, map[int]float64{2: 4},
+			[][]int{{2, 2, 1, 2, 2}},
+			[][]int{{0, 1, 0, 3, 4}}},
+		{"walls and unreachable cells", [][]int{{1, -1, 0}}, WeightingAdditive, map[int]float64{1: 10},
+			[][]int{{1, -1, 0}},
+			[][]int{{0, Unreachable, Unreachable}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkWeights(test.weighting, test.weights); err != nil {
+				t.Fatal(err)
+			}
+			b, err := newBuilding([][][]int{test.matrix}, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			labels, distances := b.weightedFlood(b.findSites(nil, nil), test.weighting, test.weights)
+			if !reflect.DeepEqual(labels[0], test.wantLabels) {
+				t.Errorf("labels = %v, want %v", labels[0], test.wantLabels)
+			}
+			if !reflect.DeepEqual(distances[0], test.wantDistances) {
+				t.Errorf("distances = %v, want %v", distances[0], test.wantDistances)
+			}
+		})
+	}
+}
+
+func TestCheckWeights(t *testing.T) {
+	tests := []struct {
+		name      string
+		weighting string
+		weights   map[int]float64
+		wantErr   bool
+	}{
+		{"multiplicative", WeightingMultiplicative, map[int]float64{1: 0.5}, false},
+		{"additive allows negative weights", WeightingAdditive, map[int]float64{1: -2}, false},
+		{"multiplicative zero weight", WeightingMultiplicative, map[int]float64{1: 0}, true},
+		{"unknown weighting", "exponential", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := checkWeights(test.weighting, test.weights); (err != nil) != test.wantErr {
+				t.Errorf("checkWeights() = %v, want error %v", err, test.wantErr)
+			}
+		})
+	}
+}